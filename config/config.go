@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Config holds the server settings. They are read from a configuration file,
+// then from TRIPLES_* environment variables, then from flags, each source
+// overriding the ones before it.
+type Config struct {
+	Port            string
+	Directory       string
+	ShutdownTimeout time.Duration
+	TLSCert         string
+	TLSKey          string
+	TLSClientCA     string
+
+	// AccessLog is a file, or - for standard output, that gets one line per
+	// request in AccessLogFormat, json or s3.
+	AccessLog        string
+	AccessLogFormat  string
+	AccessLogMaxMB   int // rotate past this size, 0 never
+	AccessLogBackups int // rotated files kept
+	// AccessLogBucket, when set, also receives the log lines as objects
+	// under AccessLogPrefix, one every AccessLogInterval.
+	AccessLogBucket   string
+	AccessLogPrefix   string
+	AccessLogInterval time.Duration
+}
+
+// Default returns the settings used when nothing else is given.
+func Default() Config {
+	return Config{
+		Port:            "8080",
+		Directory:       "data",
+		ShutdownTimeout: 30 * time.Second,
+
+		AccessLogFormat:   "json",
+		AccessLogMaxMB:    100,
+		AccessLogBackups:  5,
+		AccessLogPrefix:   "access-logs/",
+		AccessLogInterval: 5 * time.Minute,
+	}
+}
+
+// setting is one option, known by the same name in the configuration file,
+// in the environment (upper-cased, with a TRIPLES_ prefix) and as a flag.
+type setting struct {
+	name  string
+	usage string
+	set   func(c *Config, value string) error
+}
+
+var settings = []setting{
+	{"port", "Port number", func(c *Config, value string) error {
+		c.Port = value
+		return nil
+	}},
+	{"dir", "Path to the directory", func(c *Config, value string) error {
+		c.Directory = value
+		return nil
+	}},
+	{"shutdown-timeout", "How long to wait for requests in flight on SIGINT or SIGTERM", func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration such as 30s or 2m")
+		}
+		c.ShutdownTimeout = d
+		return nil
+	}},
+	{"tls-cert", "PEM certificate to serve HTTPS with", func(c *Config, value string) error {
+		c.TLSCert = value
+		return nil
+	}},
+	{"tls-key", "PEM private key of --tls-cert", func(c *Config, value string) error {
+		c.TLSKey = value
+		return nil
+	}},
+	{"tls-client-ca", "PEM CAs that client certificates must be signed by", func(c *Config, value string) error {
+		c.TLSClientCA = value
+		return nil
+	}},
+	{"access-log", "File to write an access log line per request to, - for standard output", func(c *Config, value string) error {
+		c.AccessLog = value
+		return nil
+	}},
+	{"access-log-format", "json or s3 (S3 server access log format)", func(c *Config, value string) error {
+		c.AccessLogFormat = value
+		return nil
+	}},
+	{"access-log-max-mb", "Rotate the access log past this many megabytes, 0 never", func(c *Config, value string) (err error) {
+		c.AccessLogMaxMB, err = parseCount(value)
+		return err
+	}},
+	{"access-log-backups", "How many rotated access logs to keep", func(c *Config, value string) (err error) {
+		c.AccessLogBackups, err = parseCount(value)
+		return err
+	}},
+	{"access-log-bucket", "Bucket to deliver access logs into as objects", func(c *Config, value string) error {
+		c.AccessLogBucket = value
+		return nil
+	}},
+	{"access-log-prefix", "Key prefix of the delivered access logs", func(c *Config, value string) error {
+		c.AccessLogPrefix = value
+		return nil
+	}},
+	{"access-log-interval", "How often access logs are delivered into the bucket", func(c *Config, value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return errors.New("must be a duration such as 30s or 2m")
+		}
+		c.AccessLogInterval = d
+		return nil
+	}},
+}
+
+// parseCount reads a whole number that cannot be negative.
+func parseCount(value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, errors.New("must be a whole number, 0 or more")
+	}
+	return n, nil
+}
+
+const envPrefix = "TRIPLES_"
+
+// envName is the environment variable of a setting, TRIPLES_SHUTDOWN_TIMEOUT
+// for shutdown-timeout.
+func envName(name string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
+
+func findSetting(name string) (setting, bool) {
+	name = strings.ReplaceAll(strings.ToLower(name), "_", "-")
+	for _, s := range settings {
+		if s.name == name {
+			return s, true
+		}
+	}
+	return setting{}, false
+}
+
+// Load builds the configuration from the file named by --config or
+// TRIPLES_CONFIG, the environment and the command line. It returns the
+// arguments left after the flags, such as a subcommand.
+func Load(args []string) (Config, []string, error) {
+	flags, err := parseFlags(args)
+	if err != nil {
+		return Config{}, nil, err
+	}
+
+	c := Default()
+	path := os.Getenv(envPrefix + "CONFIG")
+	if flags.configFile != "" {
+		path = flags.configFile
+	}
+	if path != "" {
+		if err = c.loadFile(path); err != nil {
+			return Config{}, nil, err
+		}
+	}
+	for _, s := range settings {
+		value, ok := os.LookupEnv(envName(s.name))
+		if !ok {
+			continue
+		}
+		if err = s.set(&c, value); err != nil {
+			return Config{}, nil, fmt.Errorf("Error: %s=%q: %v.", envName(s.name), value, err)
+		}
+	}
+	for _, s := range settings {
+		value, ok := flags.values[s.name]
+		if !ok {
+			continue
+		}
+		if err = s.set(&c, value); err != nil {
+			return Config{}, nil, fmt.Errorf("Error: --%s=%q: %v.", s.name, value, err)
+		}
+	}
+
+	if err = c.Validate(); err != nil {
+		return Config{}, nil, err
+	}
+	return c, flags.args, nil
+}
+
+// Validate checks the settings against each other and reports the first
+// problem found.
+func (c Config) Validate() error {
+	port, err := strconv.Atoi(c.Port)
+	if err != nil || port < 1 || port > 65535 {
+		return fmt.Errorf("Error: port %q must be a number between 1 and 65535.", c.Port)
+	}
+	if c.Directory == "" {
+		return errors.New("Error: dir cannot be empty.")
+	}
+	// checking that '--dir=' is standard or not
+	if isStandardPackage(c.Directory) {
+		return errors.New("Error: directory(--dir=) cannot be one of the used ones.")
+	}
+	if c.ShutdownTimeout < 0 {
+		return errors.New("Error: shutdown-timeout cannot be negative.")
+	}
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return errors.New("Error: tls-cert and tls-key must be given together.")
+	}
+	if c.TLSClientCA != "" && c.TLSCert == "" {
+		return errors.New("Error: tls-client-ca needs tls-cert and tls-key.")
+	}
+	if c.AccessLogFormat != "json" && c.AccessLogFormat != "s3" {
+		return fmt.Errorf("Error: access-log-format %q must be json or s3.", c.AccessLogFormat)
+	}
+	if c.AccessLogBucket != "" && c.AccessLogInterval <= 0 {
+		return errors.New("Error: access-log-interval must be positive to deliver into access-log-bucket.")
+	}
+	return nil
+}
+
+// MakeDirectory creates the data directory if it does not exist yet.
+func (c Config) MakeDirectory() error {
+	if _, err := os.Stat(c.Directory); os.IsNotExist(err) {
+		err = os.Mkdir(c.Directory, 0o755)
+		if err != nil {
+			return errors.New("Error: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func isStandardPackage(packageName string) bool {
+	return packageName == "cmd" || packageName == "config" || packageName == "internal"
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// fileEntry is one setting read from a configuration file. line is 0 when
+// the format does not tell.
+type fileEntry struct {
+	line  int
+	key   string
+	value string
+}
+
+// loadFile applies the settings of a .json, .yaml/.yml or .toml file. Only
+// flat files are understood: one key per setting, named like the flag
+// (tls-cert or tls_cert), with a string or number value.
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return errors.New("Error: " + err.Error())
+	}
+	var entries []fileEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		entries, err = parseJSON(data)
+	case ".yaml", ".yml":
+		entries, err = parseKeyValues(data, ":")
+	case ".toml":
+		entries, err = parseKeyValues(data, "=")
+	default:
+		return fmt.Errorf("Error: %s: configuration files must end in .json, .yaml, .yml or .toml.", path)
+	}
+	if err != nil {
+		return fmt.Errorf("Error: %s: %v.", path, err)
+	}
+
+	for _, e := range entries {
+		where := path
+		if e.line > 0 {
+			where += ":" + strconv.Itoa(e.line)
+		}
+		s, ok := findSetting(e.key)
+		if !ok {
+			return fmt.Errorf("Error: %s: unknown setting %q.", where, e.key)
+		}
+		if err = s.set(c, e.value); err != nil {
+			return fmt.Errorf("Error: %s: %s: %v.", where, e.key, err)
+		}
+	}
+	return nil
+}
+
+func parseJSON(data []byte) ([]fileEntry, error) {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var values map[string]any
+	if err := decoder.Decode(&values); err != nil {
+		return nil, err
+	}
+	entries := make([]fileEntry, 0, len(values))
+	for key, value := range values {
+		switch value := value.(type) {
+		case string:
+			entries = append(entries, fileEntry{key: key, value: value})
+		case json.Number:
+			entries = append(entries, fileEntry{key: key, value: value.String()})
+		default:
+			return nil, fmt.Errorf("%s must be a string or a number", key)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].key < entries[j].key })
+	return entries, nil
+}
+
+// parseKeyValues reads the "key: value" lines of YAML or the "key = value"
+// lines of TOML, with # comments and quoted values.
+func parseKeyValues(data []byte, separator string) ([]fileEntry, error) {
+	var entries []fileEntry
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(stripComment(line))
+		if line == "" || line == "---" {
+			continue
+		}
+		if strings.HasPrefix(line, "[") {
+			return nil, fmt.Errorf("line %d: tables are not supported, keep every setting at the top level", i+1)
+		}
+		key, value, ok := strings.Cut(line, separator)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key %s value", i+1, separator)
+		}
+		key, err := unquote(strings.TrimSpace(key))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		value = strings.TrimSpace(value)
+		if value == "" {
+			return nil, fmt.Errorf("line %d: %s has no value, nested settings are not supported", i+1, key)
+		}
+		if value, err = unquote(value); err != nil {
+			return nil, fmt.Errorf("line %d: %v", i+1, err)
+		}
+		entries = append(entries, fileEntry{line: i + 1, key: key, value: value})
+	}
+	return entries, nil
+}
+
+// stripComment cuts line at the first # outside quotes.
+func stripComment(line string) string {
+	var quote byte
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		switch {
+		case quote != 0:
+			if c == '\\' && quote == '"' {
+				i++ // an escaped character never closes the string
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '"' || c == '\'':
+			quote = c
+		case c == '#':
+			return line[:i]
+		}
+	}
+	return line
+}
+
+// unquote removes the quotes around a double- or single-quoted string.
+func unquote(value string) (string, error) {
+	switch {
+	case len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"':
+		s, err := strconv.Unquote(value)
+		if err != nil {
+			return "", fmt.Errorf("bad string %s", value)
+		}
+		return s, nil
+	case len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'':
+		return value[1 : len(value)-1], nil
+	}
+	return value, nil
+}
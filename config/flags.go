@@ -1,53 +1,64 @@
 package config
 
 import (
-	"errors"
 	"flag"
 	"fmt"
-	"os"
+	"io"
 )
 
-var (
-	PortNumber string
-	Directory  string
-)
-
-func init() {
-	flag.StringVar(&PortNumber, "port", "8080", "Port number")
-	flag.StringVar(&Directory, "dir", "data", "Path to the directory")
-
-	helpMessage := `Simple Storage Service.
+const helpMessage = `Simple Storage Service.
 
 **Usage:**
-	triple-s [-port <N>] [-dir <S>]  
+	triple-s [-config <F>] [-port <N>] [-dir <S>] [-shutdown-timeout <D>] [-tls-cert <F> -tls-key <F> [-tls-client-ca <F>]] [-access-log <F>]
+	triple-s [-dir <S>] presign [-method GET|PUT] [-expires <D>] [-access-key <K>] <bucket>/<key>
 	triple-s --help
 
 **Options:**
 - --help     Show this screen.
+- --config F Read settings from a .json, .yaml or .toml file
 - --port N   Port number
-- --dir S    Path to the directory`
+- --dir S    Path to the directory
+- --shutdown-timeout D  How long to wait for requests in flight on SIGINT or SIGTERM (default 30s)
+- --tls-cert F, --tls-key F  Serve HTTPS (and HTTP/2) with this certificate and key
+- --tls-client-ca F  Require client certificates signed by these CAs
+- --access-log F  Write one line per request to F (- for standard output)
+- --access-log-format json|s3  JSON lines or the S3 server access log format (default json)
+- --access-log-max-mb N, --access-log-backups N  Rotate the file past N MB, keeping N old ones (default 100, 5)
+- --access-log-bucket B  Also deliver the log lines as objects into bucket B
+- --access-log-prefix P, --access-log-interval D  Key prefix and period of those objects (default access-logs/, 5m)
 
-	flag.Usage = func() {
-		fmt.Println(helpMessage)
-	}
-	flag.Parse()
+Every option can also be set in the file or as TRIPLES_<OPTION> in the
+environment (TRIPLES_PORT, TRIPLES_SHUTDOWN_TIMEOUT, TRIPLES_CONFIG...).
+Flags win over the environment, which wins over the file.`
+
+// commandLine is what the flags of one run set.
+type commandLine struct {
+	configFile string
+	values     map[string]string // by setting name, only the flags given
+	args       []string
 }
 
-func ValidateDirectory() error {
-	// checking that --dir=path exists
-	if _, err := os.Stat(Directory); os.IsNotExist(err) {
-		err = os.Mkdir(Directory, 0o755)
-		if err != nil {
-			return errors.New("Error: " + err.Error())
-		}
+// parseFlags reads args without applying them, so the configuration file can
+// be loaded first. --help comes back as flag.ErrHelp.
+func parseFlags(args []string) (commandLine, error) {
+	line := commandLine{values: make(map[string]string)}
+	flags := flag.NewFlagSet("triple-s", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+	flags.StringVar(&line.configFile, "config", "", "Configuration file")
+	for _, s := range settings {
+		name := s.name
+		flags.Func(name, s.usage, func(value string) error {
+			line.values[name] = value
+			return nil
+		})
 	}
-	// checking that '--dir=' is standard or not
-	if isStandardPackage(Directory) {
-		return errors.New("Error: directory(--dir=) cannot be one of the used ones.")
+	if err := flags.Parse(args); err != nil {
+		if err == flag.ErrHelp {
+			fmt.Println(helpMessage)
+			return commandLine{}, err
+		}
+		return commandLine{}, fmt.Errorf("Error: %v. See triple-s --help.", err)
 	}
-	return nil
-}
-
-func isStandardPackage(packageName string) bool {
-	return packageName == "cmd" || packageName == "config" || packageName == "internal"
+	line.args = flags.Args()
+	return line, nil
 }
@@ -0,0 +1,440 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxPendingAccessLog is how much is kept for the log bucket before it is
+// delivered early.
+const maxPendingAccessLog = 8 << 20
+
+// AccessLogOptions says where access log lines go: to Path, - meaning
+// standard output, rotated past MaxBytes, and every Interval as an object
+// under Prefix in Bucket.
+type AccessLogOptions struct {
+	Path     string
+	Format   string // json or s3
+	MaxBytes int64  // 0 never rotates
+	Backups  int
+	Bucket   string
+	Prefix   string
+	Interval time.Duration
+}
+
+type accessLogger struct {
+	server *Server // the log bucket is in
+	format string
+
+	mu      sync.Mutex
+	closed  bool // lines of requests still running at close are dropped
+	out     io.Writer
+	file    *rotatingFile
+	bucket  string
+	prefix  string
+	pending bytes.Buffer
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// OpenAccessLog starts writing an access log line per request. It does
+// nothing when neither a file nor a bucket is given.
+func (s *Server) OpenAccessLog(options AccessLogOptions) error {
+	if options.Path == "" && options.Bucket == "" {
+		return nil
+	}
+	l := &accessLogger{server: s, format: options.Format, bucket: options.Bucket, prefix: options.Prefix}
+	switch options.Path {
+	case "":
+	case "-":
+		l.out = os.Stdout
+	default:
+		file, err := openRotatingFile(options.Path, options.MaxBytes, options.Backups)
+		if err != nil {
+			return err
+		}
+		l.file = file
+		l.out = file
+	}
+	if l.bucket != "" {
+		l.flush = make(chan struct{}, 1)
+		l.stop = make(chan struct{})
+		l.done = make(chan struct{})
+		go l.deliverEvery(options.Interval)
+	}
+	s.accessLog.Store(l)
+	return nil
+}
+
+// CloseAccessLog delivers what the log bucket has not received yet and
+// closes the log file. It must run before the metadata store is closed.
+func (s *Server) CloseAccessLog() error {
+	l := s.accessLog.Swap(nil)
+	if l == nil {
+		return nil
+	}
+	l.mu.Lock()
+	l.closed = true
+	l.mu.Unlock()
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+	if l.file != nil {
+		return l.file.Close()
+	}
+	return nil
+}
+
+// accessEntry is one request, in the json format as it is written.
+type accessEntry struct {
+	Time          string  `json:"time"`
+	RequestID     string  `json:"request_id"`
+	RemoteAddr    string  `json:"remote_addr"`
+	Requester     string  `json:"requester,omitempty"`
+	Operation     string  `json:"operation"`
+	Bucket        string  `json:"bucket,omitempty"`
+	Key           string  `json:"key,omitempty"`
+	Method        string  `json:"method"`
+	URI           string  `json:"uri"`
+	Status        int     `json:"status"`
+	ErrorCode     string  `json:"error_code,omitempty"`
+	BytesSent     int64   `json:"bytes_sent"`
+	BytesReceived int64   `json:"bytes_received"`
+	DurationMS    float64 `json:"duration_ms"`
+	UserAgent     string  `json:"user_agent,omitempty"`
+}
+
+// logAccess writes the line of a finished request.
+func (s *Server) logAccess(r *http.Request, recorder *responseRecorder, operation string, start time.Time, bytesIn int64) {
+	l := s.accessLog.Load()
+	if l == nil {
+		return
+	}
+	duration := time.Since(start)
+	bucketName, objectKey := requestTarget(r)
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+
+	var line []byte
+	if l.format == "s3" {
+		line = s3AccessLine(r, recorder, start, duration, remote, bucketName, objectKey)
+	} else {
+		line, _ = json.Marshal(accessEntry{
+			Time:          start.UTC().Format(time.RFC3339Nano),
+			RequestID:     recorder.requestID,
+			RemoteAddr:    remote,
+			Requester:     recorder.accessKey,
+			Operation:     operation,
+			Bucket:        bucketName,
+			Key:           objectKey,
+			Method:        r.Method,
+			URI:           r.RequestURI,
+			Status:        recorder.status,
+			ErrorCode:     recorder.errorCode,
+			BytesSent:     recorder.bytes,
+			BytesReceived: bytesIn,
+			DurationMS:    float64(duration.Microseconds()) / 1000,
+			UserAgent:     r.UserAgent(),
+		})
+	}
+	line = append(line, '\n')
+	l.write(line)
+}
+
+func (l *accessLogger) write(line []byte) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return
+	}
+	if l.out != nil {
+		if _, err := l.out.Write(line); err != nil {
+			log.Printf("writing the access log: %v\n", err)
+		}
+	}
+	if l.bucket != "" {
+		l.pending.Write(line)
+		if l.pending.Len() >= maxPendingAccessLog {
+			select {
+			case l.flush <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// s3AccessLine formats a request like an S3 server access log record.
+func s3AccessLine(r *http.Request, recorder *responseRecorder, start time.Time, duration time.Duration, remote, bucketName, objectKey string) []byte {
+	field := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return value
+	}
+	quoted := func(value string) string {
+		if value == "" {
+			return "-"
+		}
+		return `"` + strings.ReplaceAll(value, `"`, `\"`) + `"`
+	}
+	bytesSent := "-"
+	if recorder.bytes > 0 {
+		bytesSent = strconv.FormatInt(recorder.bytes, 10)
+	}
+	signature, authType := "-", "-"
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), signV4Algorithm+" "):
+		signature, authType = "SigV4", "AuthHeader"
+	case r.URL.Query().Get("X-Amz-Algorithm") == signV4Algorithm:
+		signature, authType = "SigV4", "QueryString"
+	}
+	cipherSuite, tlsVersion := "-", "-"
+	if r.TLS != nil {
+		cipherSuite = tls.CipherSuiteName(r.TLS.CipherSuite)
+		tlsVersion = strings.ReplaceAll(tls.VersionName(r.TLS.Version), " ", "v")
+	}
+	if objectKey != "" {
+		objectKey = url.QueryEscape(objectKey)
+	}
+
+	return []byte(strings.Join([]string{
+		"-", // bucket owner
+		field(bucketName),
+		"[" + start.Format("02/Jan/2006:15:04:05 -0700") + "]",
+		field(remote),
+		field(recorder.accessKey),
+		recorder.requestID,
+		s3LogOperation(r, bucketName, objectKey),
+		field(objectKey),
+		quoted(r.Method + " " + r.RequestURI + " " + r.Proto),
+		strconv.Itoa(recorder.status),
+		field(recorder.errorCode),
+		bytesSent,
+		"-", // object size
+		strconv.FormatInt(duration.Milliseconds(), 10),
+		"-", // turn-around time
+		quoted(r.Referer()),
+		quoted(r.UserAgent()),
+		field(r.URL.Query().Get("versionId")),
+		"-", // host id
+		signature,
+		cipherSuite,
+		authType,
+		field(r.Host),
+		tlsVersion,
+	}, " "))
+}
+
+// s3LogOperation names a request the way S3 access logs do, such as
+// REST.GET.OBJECT or REST.POST.UPLOADS.
+func s3LogOperation(r *http.Request, bucketName, objectKey string) string {
+	query := r.URL.Query()
+	copySource := r.Header.Get("X-Amz-Copy-Source") != ""
+	method := r.Method
+	resource := "OBJECT"
+	switch {
+	case bucketName == "":
+		resource = "SERVICE"
+	case objectKey == "" && query.Has("versioning"):
+		resource = "VERSIONING"
+	case objectKey == "" && query.Has("versions"):
+		resource = "BUCKETVERSIONS"
+	case query.Has("uploads"):
+		resource = "UPLOADS"
+	case objectKey == "" && query.Has("delete"):
+		resource = "MULTI_OBJECT_DELETE"
+	case objectKey == "":
+		resource = "BUCKET"
+	case query.Has("uploadId") && method == http.MethodPut && copySource:
+		method, resource = "COPY", "PART"
+	case query.Has("uploadId"):
+		resource = "UPLOAD"
+	case method == http.MethodPut && copySource:
+		method = "COPY"
+	}
+	return "REST." + method + "." + resource
+}
+
+// requestTarget finds the bucket and the key a request names, through the
+// legacy prefixes as well.
+func requestTarget(r *http.Request) (bucketName, objectKey string) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if prefix, rest, ok := strings.Cut(path, "/"); ok && isLegacyPrefix(prefix) {
+		path = rest
+	}
+	bucketName, objectKey, _ = strings.Cut(path, "/")
+	return bucketName, objectKey
+}
+
+// newRequestID returns a random ID for a request, 16 hex digits like S3's.
+func newRequestID() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+	return strings.ToUpper(hex.EncodeToString(id))
+}
+
+// deliverEvery puts the pending lines into the log bucket every interval,
+// when there are too many of them, and once more when the log is closed.
+func (l *accessLogger) deliverEvery(interval time.Duration) {
+	defer close(l.done)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-l.flush:
+		case <-l.stop:
+			l.deliver()
+			return
+		}
+		l.deliver()
+	}
+}
+
+func (l *accessLogger) deliver() {
+	l.mu.Lock()
+	data := bytes.Clone(l.pending.Bytes())
+	l.pending.Reset()
+	l.mu.Unlock()
+	if len(data) == 0 {
+		return
+	}
+	now := time.Now().UTC()
+	objectKey := l.prefix + now.Format("2006-01-02-15-04-05-") + newRequestID()
+	if err := l.server.putLogObject(l.bucket, objectKey, data); err != nil {
+		log.Printf("delivering %d bytes of access log to %s: %v\n", len(data), l.bucket, err)
+	}
+}
+
+// putLogObject stores data as a new object, the way putObject would.
+func (s *Server) putLogObject(bucketName, objectKey string, data []byte) error {
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.lock(objectLockKey(bucketName, objectKey))()
+
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		return errors.New("bucket does not exist.")
+	}
+	if bucket.Status == bucketDeleting {
+		return errors.New("bucket is being deleted.")
+	}
+	o := ObjectMD{ObjectKey: objectKey, ContentType: "text/plain"}
+	versionID, err := nextVersionID(bucket)
+	if err != nil {
+		return err
+	}
+	o.VersionID = versionID
+	target, err := s.prepareObjectPath(bucketName, &o)
+	if err != nil {
+		return err
+	}
+	size, etag, err := writeObjectFile(target, bytes.NewReader(data), nil)
+	if err != nil {
+		return err
+	}
+	o.Size = strconv.FormatInt(size, 10)
+	o.LastModified = time.Now().Format(timeFormat)
+	o.ETag = etag
+	return s.commitObject(&Tx{}, bucketName, o)
+}
+
+// rotatingFile appends to path and, once it would grow past maxBytes, moves
+// it to path.1, path.1 to path.2 and so on, keeping backups old files.
+type rotatingFile struct {
+	path     string
+	maxBytes int64
+	backups  int
+	file     *os.File
+	size     int64
+}
+
+func openRotatingFile(path string, maxBytes int64, backups int) (*rotatingFile, error) {
+	f := &rotatingFile{path: path, maxBytes: maxBytes, backups: backups}
+	if err := f.open(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (f *rotatingFile) open() error {
+	file, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// Write is called with the logger's lock held.
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	if f.maxBytes > 0 && f.size > 0 && f.size+int64(len(p)) > f.maxBytes {
+		if err := f.rotate(); err != nil {
+			// the current file is still open, the next write tries again
+			log.Printf("rotating the access log: %v\n", err)
+		}
+	}
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// rotate moves the file aside while it is still open, so that on any failure
+// it is left at path and writes carry on into it.
+func (f *rotatingFile) rotate() error {
+	if f.backups == 0 {
+		if err := f.file.Truncate(0); err != nil {
+			return err
+		}
+		f.size = 0
+		return nil
+	}
+	backup := func(i int) string { return fmt.Sprintf("%s.%d", f.path, i) }
+	if err := os.Remove(backup(f.backups)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := f.backups - 1; i >= 1; i-- {
+		if err := os.Rename(backup(i), backup(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(f.path, backup(1)); err != nil {
+		return err
+	}
+	old := f.file
+	if err := f.open(); err != nil {
+		os.Rename(backup(1), f.path)
+		return err
+	}
+	old.Close()
+	return nil
+}
+
+func (f *rotatingFile) Close() error {
+	return f.file.Close()
+}
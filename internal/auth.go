@@ -0,0 +1,361 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	signV4Algorithm  = "AWS4-HMAC-SHA256"
+	amzDateFormat    = "20060102T150405Z"
+	unsignedPayload  = "UNSIGNED-PAYLOAD"
+	emptySHA256      = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	maxClockSkew     = 15 * time.Minute
+	maxPresignExpiry = 7 * 24 * time.Hour
+)
+
+var errContentSHA256Mismatch = errors.New("The provided 'x-amz-content-sha256' header does not match what was computed.")
+
+// Credentials are the access keys requests can be signed with. Without any,
+// every request is let through, as before authentication existed.
+type Credentials struct {
+	secrets map[string]string // secret key by access key ID
+	admins  map[string]bool   // keys with the admin role, which may force delete buckets
+}
+
+type contextKey string
+
+// accessKeyContextKey carries the access key a request was signed with.
+const accessKeyContextKey contextKey = "accessKey"
+
+// LoadCredentials reads _meta/credentials.csv, with one
+// "AccessKeyId,SecretAccessKey[,Role]" row per user under a header row.
+func LoadCredentials(directory string) (Credentials, error) {
+	path := filepath.Join(directory, metaDirName, "credentials.csv")
+	records, err := readCSV(path)
+	if os.IsNotExist(err) {
+		log.Printf("no %s, authentication is disabled\n", path)
+		return Credentials{}, nil
+	}
+	if err != nil {
+		return Credentials{}, err
+	}
+	c := Credentials{secrets: make(map[string]string), admins: make(map[string]bool)}
+	for i, record := range records {
+		if i == 0 || len(record) < 2 {
+			continue
+		}
+		accessKey := strings.TrimSpace(record[0])
+		c.secrets[accessKey] = strings.TrimSpace(record[1])
+		if len(record) > 2 && strings.TrimSpace(record[2]) == "admin" {
+			c.admins[accessKey] = true
+		}
+	}
+	if len(c.secrets) == 0 {
+		return Credentials{}, errors.New("Error: " + path + " holds no credentials.")
+	}
+	return c, nil
+}
+
+// isAdmin reports whether r was signed with an admin access key. Without
+// credentials nobody is an admin.
+func (s *Server) isAdmin(r *http.Request) bool {
+	accessKey, _ := r.Context().Value(accessKeyContextKey).(string)
+	return s.credentials.admins[accessKey]
+}
+
+// authError is a failed verification, ready to be sent through writeXMLError.
+type authError struct {
+	code    string
+	message string
+}
+
+func accessDenied(message string) *authError {
+	return &authError{"AccessDenied", message}
+}
+
+// authenticate verifies AWS Signature Version 4, sent either in the
+// Authorization header or in the query string, before calling next.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var sig *signature
+		if len(s.credentials.secrets) > 0 {
+			var err *authError
+			sig, err = s.verifyRequest(r)
+			if err != nil {
+				writeXMLError(w, err.code, err.message)
+				return
+			}
+			r = r.WithContext(context.WithValue(r.Context(), accessKeyContextKey, sig.accessKey))
+			recordAccessKey(w, sig.accessKey)
+		}
+
+		switch {
+		case isChunkedPayload(r):
+			r.Body = newChunkedReader(r.Body, sig)
+		case sig != nil && sig.payloadHash != unsignedPayload:
+			// the payload hash is signed, make sure the body really has it
+			r.Body = &sha256Reader{body: r.Body, hash: sha256.New(), expected: sig.payloadHash}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// signature holds the parts of a SigV4 signature, whichever way it was sent.
+type signature struct {
+	accessKey     string
+	date          time.Time
+	scope         string // date/region/service/aws4_request
+	signedHeaders []string
+	signature     string
+	payloadHash   string
+	key           []byte // derived signing key, once verified
+}
+
+func (s *Server) verifyRequest(r *http.Request) (*signature, *authError) {
+	var sig *signature
+	var err *authError
+	switch {
+	case strings.HasPrefix(r.Header.Get("Authorization"), signV4Algorithm+" "):
+		sig, err = parseAuthorizationHeader(r)
+	case r.URL.Query().Get("X-Amz-Algorithm") == signV4Algorithm:
+		sig, err = parsePresignedQuery(r)
+	case r.Header.Get("Authorization") != "" || r.URL.Query().Has("X-Amz-Algorithm"):
+		return nil, &authError{"InvalidRequest", "Only AWS Signature Version 4 is supported."}
+	default:
+		return nil, accessDenied("Access Denied.")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	secret, ok := s.credentials.secrets[sig.accessKey]
+	if !ok {
+		return nil, &authError{"InvalidAccessKeyId", ""}
+	}
+	sig.key = signingKey(secret, sig.scope)
+	expected := hex.EncodeToString(hmacSHA256(sig.key, stringToSign(sig, canonicalRequest(r, sig))))
+	if !hmac.Equal([]byte(expected), []byte(sig.signature)) {
+		return nil, &authError{"SignatureDoesNotMatch", ""}
+	}
+	return sig, nil
+}
+
+func parseAuthorizationHeader(r *http.Request) (*signature, *authError) {
+	malformed := &authError{"AuthorizationHeaderMalformed", ""}
+	fields := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(r.Header.Get("Authorization"), signV4Algorithm+" "), ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return nil, malformed
+		}
+		fields[name] = value
+	}
+	sig, err := newSignature(fields["Credential"], fields["SignedHeaders"], fields["Signature"], malformed)
+	if err != nil {
+		return nil, err
+	}
+
+	amzDate := r.Header.Get("X-Amz-Date")
+	if amzDate == "" {
+		amzDate = r.Header.Get("Date")
+	}
+	date, parseErr := time.Parse(amzDateFormat, amzDate)
+	if parseErr != nil {
+		if date, parseErr = http.ParseTime(amzDate); parseErr != nil {
+			return nil, accessDenied("AWS authentication requires a valid Date or x-amz-date header.")
+		}
+	}
+	if skew := time.Since(date); skew > maxClockSkew || skew < -maxClockSkew {
+		return nil, &authError{"RequestTimeTooSkewed", ""}
+	}
+	sig.date = date
+
+	sig.payloadHash = r.Header.Get("X-Amz-Content-Sha256")
+	if sig.payloadHash == "" {
+		return nil, &authError{"InvalidRequest", "Missing required header for this request: x-amz-content-sha256."}
+	}
+	return sig, nil
+}
+
+func parsePresignedQuery(r *http.Request) (*signature, *authError) {
+	malformed := &authError{"AuthorizationQueryParametersError", ""}
+	query := r.URL.Query()
+	sig, err := newSignature(query.Get("X-Amz-Credential"), query.Get("X-Amz-SignedHeaders"), query.Get("X-Amz-Signature"), malformed)
+	if err != nil {
+		return nil, err
+	}
+
+	date, parseErr := time.Parse(amzDateFormat, query.Get("X-Amz-Date"))
+	if parseErr != nil {
+		return nil, malformed
+	}
+	expires, parseErr := strconv.Atoi(query.Get("X-Amz-Expires"))
+	if parseErr != nil || expires < 0 || time.Duration(expires)*time.Second > maxPresignExpiry {
+		return nil, malformed
+	}
+	if time.Now().After(date.Add(time.Duration(expires) * time.Second)) {
+		return nil, accessDenied("Request has expired.")
+	}
+	if date.After(time.Now().Add(maxClockSkew)) {
+		return nil, accessDenied("Request is not valid yet.")
+	}
+	sig.date = date
+
+	sig.payloadHash = unsignedPayload
+	if value := query.Get("X-Amz-Content-Sha256"); value != "" {
+		sig.payloadHash = value
+	}
+	return sig, nil
+}
+
+func newSignature(credential, signedHeaders, sig string, malformed *authError) (*signature, *authError) {
+	parts := strings.Split(credential, "/")
+	if len(parts) != 5 || parts[4] != "aws4_request" || signedHeaders == "" || sig == "" {
+		return nil, malformed
+	}
+	if parts[3] != "s3" {
+		return nil, malformed
+	}
+	return &signature{
+		accessKey:     parts[0],
+		scope:         strings.Join(parts[1:], "/"),
+		signedHeaders: strings.Split(signedHeaders, ";"),
+		signature:     sig,
+	}, nil
+}
+
+func canonicalRequest(r *http.Request, sig *signature) string {
+	var headers strings.Builder
+	for _, name := range sig.signedHeaders {
+		headers.WriteString(name + ":" + canonicalHeaderValue(r, name) + "\n")
+	}
+	return strings.Join([]string{
+		r.Method,
+		awsURIEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.RawQuery),
+		headers.String(),
+		strings.Join(sig.signedHeaders, ";"),
+		sig.payloadHash,
+	}, "\n")
+}
+
+func canonicalHeaderValue(r *http.Request, name string) string {
+	var values []string
+	switch name {
+	case "host":
+		values = []string{r.Host}
+	case "content-length":
+		values = r.Header.Values("Content-Length")
+		if len(values) == 0 {
+			values = []string{strconv.FormatInt(r.ContentLength, 10)}
+		}
+	default:
+		values = r.Header.Values(name)
+	}
+	for i, value := range values {
+		values[i] = strings.Join(strings.Fields(value), " ")
+	}
+	return strings.Join(values, ",")
+}
+
+// canonicalQuery sorts the query parameters and re-encodes them the way SigV4
+// expects, leaving out the signature itself.
+func canonicalQuery(rawQuery string) string {
+	var pairs []string
+	for _, pair := range strings.Split(rawQuery, "&") {
+		if pair == "" {
+			continue
+		}
+		name, value, _ := strings.Cut(pair, "=")
+		name, _ = url.QueryUnescape(name)
+		value, _ = url.QueryUnescape(value)
+		if name == "X-Amz-Signature" {
+			continue
+		}
+		pairs = append(pairs, awsURIEncode(name, true)+"="+awsURIEncode(value, true))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "&")
+}
+
+// awsURIEncode percent-encodes everything but the unreserved characters of
+// RFC 3986. Slashes are kept unless encodeSlash is set.
+func awsURIEncode(value string, encodeSlash bool) string {
+	const hexDigits = "0123456789ABCDEF"
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			b.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			b.WriteByte(c)
+		default:
+			b.WriteByte('%')
+			b.WriteByte(hexDigits[c>>4])
+			b.WriteByte(hexDigits[c&15])
+		}
+	}
+	return b.String()
+}
+
+func stringToSign(sig *signature, canonical string) string {
+	hash := sha256.Sum256([]byte(canonical))
+	return strings.Join([]string{
+		signV4Algorithm,
+		sig.date.UTC().Format(amzDateFormat),
+		sig.scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+}
+
+func signingKey(secret, scope string) []byte {
+	key := []byte("AWS4" + secret)
+	for _, part := range strings.Split(scope, "/") {
+		key = hmacSHA256(key, part)
+	}
+	return key
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// sha256Reader fails the read that reaches the end of the body when the body
+// does not hash to the signed x-amz-content-sha256 value.
+type sha256Reader struct {
+	body     io.ReadCloser
+	hash     hash.Hash
+	expected string
+}
+
+func (s *sha256Reader) Read(p []byte) (int, error) {
+	n, err := s.body.Read(p)
+	s.hash.Write(p[:n])
+	if err == io.EOF && hex.EncodeToString(s.hash.Sum(nil)) != s.expected {
+		return n, errContentSHA256Mismatch
+	}
+	return n, err
+}
+
+func (s *sha256Reader) Close() error {
+	return s.body.Close()
+}
@@ -4,67 +4,108 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
-	"triple-s/config"
+	"strings"
+	"time"
 )
 
-func PutHandler(w http.ResponseWriter, r *http.Request) {
+// A bucket is Active until a force delete marks it Deleting, after which it
+// takes no more writes.
+const (
+	bucketActive   = "Active"
+	bucketDeleting = "Deleting"
+)
+
+func (s *Server) PutHandler(w http.ResponseWriter, r *http.Request) {
 	// http errors handling
 	if r.Method != http.MethodPut {
-		writeXMLError(w, "MethodNotAllowed", "Error: only PUT command for /put/ url.", http.StatusMethodNotAllowed)
+		writeXMLError(w, "MethodNotAllowed", "Only PUT command for /put/ url.")
 		return
 	}
 
-	bucketName := r.URL.Path[len("/put/"):]
+	bucketName := strings.TrimSuffix(r.URL.Path[len("/put/"):], "/")
 	if bucketName == "" {
-		writeXMLError(w, "BadRequest", "Error: bucket name cannot be empty.", http.StatusBadRequest)
+		writeXMLError(w, "InvalidBucketName", "Bucket name cannot be empty.")
 		return
 	}
+	s.createBucket(w, r, bucketName)
+}
+
+func (s *Server) createBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
 	// checking the correctness of bucket name
 	err := validateBucketName(bucketName)
 	if err != nil {
-		writeXMLError(w, "BadRequest", "Error: "+err.Error(), http.StatusBadRequest)
+		writeXMLError(w, "InvalidBucketName", err.Error())
 		return
 	}
 
+	defer s.locks.lock(bucketLockKey(bucketName))()
+
 	// checking the uniqueness of bucket name
-	elementIn, err := elementExists("/buckets.csv", bucketName)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if elementIn {
-		writeXMLError(w, "Conflict", "Error: The bucket name is already in use.", http.StatusConflict)
+	if _, ok := s.store.Bucket(bucketName); ok {
+		writeXMLError(w, "BucketAlreadyOwnedByYou", "")
 		return
 	}
 	// the creation of bucket
-	err = os.Mkdir(filepath.Join(config.Directory, bucketName), 0o755)
+	err = os.Mkdir(filepath.Join(s.directory, bucketName), 0o755)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
 	// fullfilling the bucket metadata
-	err = writeBucketCSV(bucketName)
+	now := time.Now().Format(timeFormat)
+	tx := &Tx{}
+	tx.PutBucket(Bucket{
+		Name:             bucketName,
+		DateOfCreation:   now,
+		LastModifiedTime: now,
+		Status:           bucketActive,
+	})
+	err = s.store.Commit(tx)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		os.Remove(filepath.Join(s.directory, bucketName))
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
 	// the finish line
+	w.Header().Set("Location", "/"+bucketName)
 	writeXMLResponse(w, "OK", "Successful creation of bucket!", http.StatusOK)
 }
 
-func GetHandler(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetHandler(w http.ResponseWriter, r *http.Request) {
 	// http errors checking
 	if r.Method != http.MethodGet {
-		writeXMLError(w, "MethodNotAllowed", "Error: only GET command in /get/ url.", http.StatusMethodNotAllowed)
+		writeXMLError(w, "MethodNotAllowed", "Only GET command in /get/ url.")
 		return
 	}
-	if len(r.URL.Path[len("/get/"):]) > 0 {
-		writeXMLError(w, "Conflict", "Error: too much data after /get/", http.StatusConflict)
+	if bucketName := strings.TrimSuffix(r.URL.Path[len("/get/"):], "/"); bucketName != "" {
+		s.listObjects(w, r, bucketName)
 		return
 	}
-	xmlData, err := listAllMyBucketsResult()
+	s.listLegacyBuckets(w, r)
+}
+
+// listBuckets answers ListBuckets. Buckets belong to the server rather than
+// to a user, so the owner is whoever signed the request.
+func (s *Server) listBuckets(w http.ResponseWriter, r *http.Request) {
+	owner, _ := r.Context().Value(accessKeyContextKey).(string)
+	if owner == "" {
+		owner = "triple-s"
+	}
+	result := ListBucketsResult{Owner: Owner{ID: owner, DisplayName: owner}}
+	for _, b := range s.store.Buckets() {
+		result.Buckets = append(result.Buckets, BucketInfo{
+			Name:         b.Name,
+			CreationDate: parseTime(b.DateOfCreation).UTC().Format(isoTimeFormat),
+		})
+	}
+	writeXML(w, result, http.StatusOK)
+}
+
+// listLegacyBuckets lists the buckets in the shape /get/ always used.
+func (s *Server) listLegacyBuckets(w http.ResponseWriter, r *http.Request) {
+	xmlData, err := s.listAllMyBucketsResult()
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
 	w.Header().Set("Content-Type", "application/xml")
@@ -72,60 +113,76 @@ func GetHandler(w http.ResponseWriter, r *http.Request) {
 	w.Write(xmlData)
 }
 
-func DeleteHandler(w http.ResponseWriter, r *http.Request) {
+// headBucket tells whether a bucket exists.
+func (s *Server) headBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) DeleteHandler(w http.ResponseWriter, r *http.Request) {
 	// http errors handling
 	if r.Method != http.MethodDelete {
-		writeXMLError(w, "MethodNotAllowed", "Error: only DELETE command in /delete/ url.", http.StatusMethodNotAllowed)
+		writeXMLError(w, "MethodNotAllowed", "Only DELETE command in /delete/ url.")
 		return
 	}
-	target := r.URL.Path[len("/delete/"):]
+	target := strings.TrimSuffix(r.URL.Path[len("/delete/"):], "/")
 	if target == "" {
-		writeXMLError(w, "BadRequest", "Error: bucket name cannot be empty.", http.StatusBadRequest)
+		writeXMLError(w, "InvalidBucketName", "Bucket name cannot be empty.")
 		return
 	}
+	s.deleteBucket(w, r, target)
+}
 
-	// handle if bucket does not exists
-	is, err := isBucketEmpty(config.Directory)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+// deleteBucket removes a bucket that holds no objects, versions or delete
+// markers. Multipart uploads in progress go with it.
+func (s *Server) deleteBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if r.URL.Query().Has("force") {
+		s.forceDeleteBucket(w, r, bucketName)
 		return
 	}
-	if is {
-		writeXMLError(w, "NotFound", "Error: bucket does not exist.", http.StatusNotFound)
+
+	// waits for every object handler working in the bucket
+	defer s.locks.lock(bucketLockKey(bucketName))()
+
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
 		return
 	}
-
-	// checking for existing bucket
-	path := config.Directory + "/" + target
-	elementIn, err := elementExists("/buckets.csv", target)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if elementIn {
-		if is, err = bucketForDeletion("/buckets.csv", target); err != nil {
-			writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
-			return
-		} else if is {
-			err = os.RemoveAll(path)
-			if err != nil {
-				writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			err = deleteRecord("/buckets.csv", target)
-			if err != nil {
-				writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
-				return
-			}
-			w.WriteHeader(http.StatusNoContent)
-			return
-
-		} else {
-			writeXMLError(w, "Confilct", "Error: bucket is not empty.", http.StatusConflict)
-			return
-		}
-	} else {
-		writeXMLError(w, "NotFound", "Error: bucket does not exist.", http.StatusNotFound)
+	if !checkBucketWritable(w, bucket) {
 		return
 	}
+	if s.store.KeyCount(bucketName) > 0 {
+		writeXMLError(w, "BucketNotEmpty", "")
+		return
+	}
+	if err := s.removeBucket(bucketName); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// removeBucket drops the metadata and the directory of a bucket. The caller
+// holds the bucket lock exclusively.
+func (s *Server) removeBucket(bucketName string) error {
+	tx := &Tx{}
+	tx.DeleteBucket(bucketName)
+	if err := s.store.Commit(tx); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(s.directory, bucketName))
+}
+
+// checkBucketWritable refuses changes to a bucket that is being force
+// deleted.
+func checkBucketWritable(w http.ResponseWriter, bucket Bucket) bool {
+	if bucket.Status == bucketDeleting {
+		writeXMLError(w, "OperationAborted", "A conflicting operation is in progress against this bucket: it is being deleted.")
+		return false
+	}
+	return true
 }
@@ -0,0 +1,165 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const streamingPayloadAlgorithm = "AWS4-HMAC-SHA256-PAYLOAD"
+
+var errMalformedChunk = errors.New("The aws-chunked request body is malformed.")
+
+var errChunkSignature = errors.New("The chunk signature we calculated does not match the signature you provided.")
+
+// isChunkedPayload reports whether the body uses the aws-chunked encoding
+// SDKs send for streaming uploads.
+func isChunkedPayload(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") ||
+		strings.Contains(r.Header.Get("Content-Encoding"), "aws-chunked")
+}
+
+// chunkedReader decodes an aws-chunked body:
+//
+//	<hex size>[;chunk-signature=<sig>]\r\n<data>\r\n ... 0[;chunk-signature=<sig>]\r\n[trailers]\r\n
+//
+// When the request was signed with STREAMING-AWS4-HMAC-SHA256-PAYLOAD every
+// chunk signature is checked against the seed signature chain.
+type chunkedReader struct {
+	reader    *bufio.Reader
+	body      io.Closer
+	sig       *signature // nil when chunk signatures are not checked
+	prev      string
+	chunkSig  string
+	remaining int64
+	inChunk   bool
+	hash      hash.Hash
+	err       error
+}
+
+func newChunkedReader(body io.ReadCloser, sig *signature) *chunkedReader {
+	c := &chunkedReader{
+		reader: bufio.NewReader(body),
+		body:   body,
+		hash:   sha256.New(),
+	}
+	if sig != nil && strings.HasPrefix(sig.payloadHash, "STREAMING-AWS4-HMAC-SHA256-PAYLOAD") {
+		c.sig = sig
+		c.prev = sig.signature
+	}
+	return c
+}
+
+func (c *chunkedReader) Read(p []byte) (int, error) {
+	for c.err == nil && c.remaining == 0 {
+		if c.inChunk {
+			c.err = c.endChunk()
+			continue
+		}
+		c.err = c.startChunk()
+	}
+	if c.err != nil {
+		return 0, c.err
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+	n, err := c.reader.Read(p)
+	c.hash.Write(p[:n])
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		c.err = err
+	}
+	return n, err
+}
+
+// startChunk reads a chunk header. The final, empty chunk is verified and the
+// trailers after it are skipped.
+func (c *chunkedReader) startChunk() error {
+	line, err := c.readLine()
+	if err != nil {
+		return err
+	}
+	size, extension, _ := strings.Cut(line, ";")
+	n, err := strconv.ParseInt(strings.TrimSpace(size), 16, 64)
+	if err != nil || n < 0 {
+		return errMalformedChunk
+	}
+	c.chunkSig = strings.TrimPrefix(strings.TrimSpace(extension), "chunk-signature=")
+	c.hash.Reset()
+
+	if n > 0 {
+		c.remaining = n
+		c.inChunk = true
+		return nil
+	}
+
+	if err = c.verifyChunk(); err != nil {
+		return err
+	}
+	for {
+		line, err = c.readLine()
+		if err == io.EOF || (err == nil && line == "") {
+			return io.EOF
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// endChunk checks the CRLF and the signature that close a data chunk.
+func (c *chunkedReader) endChunk() error {
+	c.inChunk = false
+	line, err := c.readLine()
+	if err != nil || line != "" {
+		return errMalformedChunk
+	}
+	return c.verifyChunk()
+}
+
+func (c *chunkedReader) verifyChunk() error {
+	if c.sig == nil {
+		return nil
+	}
+	toSign := strings.Join([]string{
+		streamingPayloadAlgorithm,
+		c.sig.date.UTC().Format(amzDateFormat),
+		c.sig.scope,
+		c.prev,
+		emptySHA256,
+		hex.EncodeToString(c.hash.Sum(nil)),
+	}, "\n")
+	expected := hex.EncodeToString(hmacSHA256(c.sig.key, toSign))
+	if !hmac.Equal([]byte(expected), []byte(c.chunkSig)) {
+		return errChunkSignature
+	}
+	c.prev = c.chunkSig
+	return nil
+}
+
+func (c *chunkedReader) readLine() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		if err == io.EOF && line != "" {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func (c *chunkedReader) Close() error {
+	return c.body.Close()
+}
@@ -0,0 +1,105 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+)
+
+// checkPreconditions evaluates If-Match, If-Unmodified-Since, If-None-Match
+// and If-Modified-Since in the order of RFC 9110 against the stored object.
+// exists is false when the key has no object yet, which matters for PUT.
+// When a condition fails the response is written and false is returned.
+func checkPreconditions(w http.ResponseWriter, r *http.Request, o ObjectMD, exists bool) bool {
+	readOnly := r.Method == http.MethodGet || r.Method == http.MethodHead
+	modified := parseTime(o.LastModified)
+
+	if value := r.Header.Get("If-Match"); value != "" {
+		if !exists || !etagMatches(value, o.ETag) {
+			writePreconditionFailed(w, "If-Match")
+			return false
+		}
+	} else if value := r.Header.Get("If-Unmodified-Since"); value != "" && exists {
+		if t, err := http.ParseTime(value); err == nil && modified.After(t) {
+			writePreconditionFailed(w, "If-Unmodified-Since")
+			return false
+		}
+	}
+
+	if value := r.Header.Get("If-None-Match"); value != "" {
+		if exists && etagMatches(value, o.ETag) {
+			if readOnly {
+				writeNotModified(w, o)
+			} else {
+				writePreconditionFailed(w, "If-None-Match")
+			}
+			return false
+		}
+	} else if value := r.Header.Get("If-Modified-Since"); value != "" && readOnly && exists {
+		if t, err := http.ParseTime(value); err == nil && !modified.After(t) {
+			writeNotModified(w, o)
+			return false
+		}
+	}
+	return true
+}
+
+// checkCopySourcePreconditions evaluates the x-amz-copy-source-if-* headers
+// of a copy against its source. As in S3 a matching if-match outweighs a
+// failed if-unmodified-since, and a failed if-none-match outweighs
+// if-modified-since. Every failure is a 412.
+func checkCopySourcePreconditions(w http.ResponseWriter, r *http.Request, src ObjectMD) bool {
+	modified := parseTime(src.LastModified)
+
+	if value := r.Header.Get("X-Amz-Copy-Source-If-Match"); value != "" {
+		if !etagMatches(value, src.ETag) {
+			writePreconditionFailed(w, "x-amz-copy-source-If-Match")
+			return false
+		}
+	} else if value := r.Header.Get("X-Amz-Copy-Source-If-Unmodified-Since"); value != "" {
+		if t, err := http.ParseTime(value); err == nil && modified.After(t) {
+			writePreconditionFailed(w, "x-amz-copy-source-If-Unmodified-Since")
+			return false
+		}
+	}
+
+	if value := r.Header.Get("X-Amz-Copy-Source-If-None-Match"); value != "" {
+		if etagMatches(value, src.ETag) {
+			writePreconditionFailed(w, "x-amz-copy-source-If-None-Match")
+			return false
+		}
+	} else if value := r.Header.Get("X-Amz-Copy-Source-If-Modified-Since"); value != "" {
+		if t, err := http.ParseTime(value); err == nil && !modified.After(t) {
+			writePreconditionFailed(w, "x-amz-copy-source-If-Modified-Since")
+			return false
+		}
+	}
+	return true
+}
+
+// etagMatches reports whether a list of entity tags from a conditional
+// header names etag. Weak tags are compared weakly.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		candidate = strings.TrimPrefix(candidate, "W/")
+		if strings.Trim(candidate, `"`) == etag && etag != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func writeNotModified(w http.ResponseWriter, o ObjectMD) {
+	if o.ETag != "" {
+		w.Header().Set("ETag", quoteETag(o.ETag))
+	}
+	w.Header().Set("Last-Modified", parseTime(o.LastModified).UTC().Format(http.TimeFormat))
+	w.WriteHeader(http.StatusNotModified)
+}
+
+func writePreconditionFailed(w http.ResponseWriter, condition string) {
+	writeXMLError(w, "PreconditionFailed", "At least one of the pre-conditions you specified did not hold: "+condition+".")
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errInvalidCopySource = errors.New("Copy Source must mention the source bucket and key: sourcebucket/sourcekey.")
+
+// parseCopySource reads x-amz-copy-source, "[/]bucket/key[?versionId=ID]"
+// with the key URL-encoded.
+func parseCopySource(value string) (bucketName, objectKey, versionID string, err error) {
+	path, query, _ := strings.Cut(value, "?")
+	path, err = url.PathUnescape(path)
+	if err != nil {
+		return "", "", "", errInvalidCopySource
+	}
+	bucketName, objectKey, ok := strings.Cut(strings.TrimPrefix(path, "/"), "/")
+	if !ok || bucketName == "" || objectKey == "" {
+		return "", "", "", errInvalidCopySource
+	}
+	if err = validateObjectKey(objectKey); err != nil {
+		return "", "", "", err
+	}
+	if query != "" {
+		values, err := url.ParseQuery(query)
+		if err != nil {
+			return "", "", "", errInvalidCopySource
+		}
+		versionID = values.Get("versionId")
+	}
+	return bucketName, objectKey, versionID, nil
+}
+
+// copyObject answers PUT /{bucket}/{key} with x-amz-copy-source by copying
+// the source object on the server.
+func (s *Server) copyObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	srcBucketName, srcKey, srcVersionID, err := parseCopySource(r.Header.Get("X-Amz-Copy-Source"))
+	if err != nil {
+		if err == errKeyTooLong || err == errKeyInvalid {
+			writeKeyError(w, err)
+		} else {
+			writeXMLError(w, "InvalidArgument", err.Error())
+		}
+		return
+	}
+	directive := r.Header.Get("X-Amz-Metadata-Directive")
+	if directive != "" && directive != "COPY" && directive != "REPLACE" {
+		writeXMLError(w, "InvalidArgument", "Unknown metadata directive.")
+		return
+	}
+	sameObject := srcBucketName == bucketName && srcKey == objectKey
+	if sameObject && directive != "REPLACE" && srcVersionID == "" {
+		writeXMLError(w, "InvalidRequest", "This copy request is illegal because it is trying to copy an object to itself without changing the object's metadata.")
+		return
+	}
+
+	// two buckets and two objects: take each kind in name order
+	for _, name := range sortedUnique(bucketLockKey(srcBucketName), bucketLockKey(bucketName)) {
+		defer s.locks.rlock(name)()
+	}
+	if sameObject {
+		defer s.locks.lock(objectLockKey(bucketName, objectKey))()
+	} else {
+		src, dst := objectLockKey(srcBucketName, srcKey), objectLockKey(bucketName, objectKey)
+		if src < dst {
+			defer s.locks.rlock(src)()
+			defer s.locks.lock(dst)()
+		} else {
+			defer s.locks.lock(dst)()
+			defer s.locks.rlock(src)()
+		}
+	}
+
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	if !checkBucketWritable(w, bucket) {
+		return
+	}
+	if _, ok = s.store.Bucket(srcBucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "Source bucket does not exist.")
+		return
+	}
+	src, err := s.findObjectVersion(http.Header{}, srcBucketName, srcKey, srcVersionID)
+	switch err {
+	case nil:
+	case errDeleteMarker:
+		writeXMLError(w, "InvalidRequest", "The source of a copy request may not specifically refer to a delete marker by version id.")
+		return
+	default:
+		writeVersionError(w, err)
+		return
+	}
+	if !checkCopySourcePreconditions(w, r, src) {
+		return
+	}
+	current, exists := s.store.Object(bucketName, objectKey)
+	if !checkPreconditions(w, r, current, exists) {
+		return
+	}
+
+	// COPY keeps the source headers, REPLACE takes them from this request
+	o := ObjectMD{
+		ObjectKey:     objectKey,
+		ContentType:   src.ContentType,
+		ObjectHeaders: src.ObjectHeaders,
+	}
+	if directive == "REPLACE" {
+		if o.ObjectHeaders, err = readObjectHeaders(r); err != nil {
+			writeXMLError(w, "MetadataTooLarge", err.Error())
+			return
+		}
+		o.ContentType = r.Header.Get("Content-Type")
+	}
+	if o.VersionID, err = nextVersionID(bucket); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+
+	file, err := os.Open(s.objectPath(srcBucketName, src))
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	defer file.Close()
+	target, err := s.prepareObjectPath(bucketName, &o)
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	size, etag, err := writeObjectFile(target, file, nil)
+	if err != nil {
+		writeXMLError(w, "InternalError", "Error writing file: "+err.Error())
+		return
+	}
+
+	o.Size = strconv.FormatInt(size, 10)
+	o.LastModified = time.Now().Format(timeFormat)
+	o.ETag = etag
+	if err = s.commitObject(&Tx{}, bucketName, o); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+
+	if src.VersionID != "" {
+		w.Header().Set("x-amz-copy-source-version-id", src.VersionID)
+	}
+	if o.VersionID != "" {
+		w.Header().Set("x-amz-version-id", o.VersionID)
+	}
+	writeXML(w, CopyObjectResult{
+		LastModified: parseTime(o.LastModified).UTC().Format(isoTimeFormat),
+		ETag:         quoteETag(etag),
+	}, http.StatusOK)
+}
+
+// sortedUnique returns the given names once each, in order.
+func sortedUnique(a, b string) []string {
+	switch {
+	case a == b:
+		return []string{a}
+	case a < b:
+		return []string{a, b}
+	}
+	return []string{b, a}
+}
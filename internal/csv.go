@@ -3,106 +3,108 @@ package internal
 import (
 	"encoding/csv"
 	"os"
-	"time"
-	"triple-s/config"
+	"path/filepath"
 )
 
-func writeBucketCSV(bucketName string) error {
-	file, err := os.OpenFile(config.Directory+"/buckets.csv", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
+// csvMigratedName marks, inside _meta, that the CSV layout has been dealt
+// with. Past that point buckets.csv can only be a bucket of that name.
+const csvMigratedName = "csv-migrated"
 
-	if fileInfo, err := os.Stat(file.Name()); err != nil {
-		return err
-	} else if fileInfo.Size() == 0 {
-		writer.Write([]string{"Name", "DateOfCreation", "LastModifiedTime", "Status"})
+// migrateCSV imports the old CSV layout, if there is one, on the first start
+// that gets through it. A bucket named buckets.csv is not the old layout.
+func migrateCSV(directory string, store MetaStore) error {
+	marker := filepath.Join(directory, metaDirName, csvMigratedName)
+	if _, err := os.Stat(marker); err == nil {
+		return nil
 	}
-
-	err = writer.Write([]string{bucketName, time.Now().Format("2006/01/02 15:04:05"), time.Now().Format("2006/01/02 15:04:05"), "MarkedForDeletion"})
-	if err != nil {
+	bucketsCSV := filepath.Join(directory, "buckets.csv")
+	info, err := os.Stat(bucketsCSV)
+	if err != nil && !os.IsNotExist(err) {
 		return err
 	}
-	return nil
+	if err == nil && !info.IsDir() {
+		if err = importCSV(directory, store); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(marker, nil, 0o644)
 }
 
-func updateBucketCSV(status string, bucketName string) error {
-	file, err := os.OpenFile(config.Directory+"/buckets.csv", os.O_RDWR, 0o644)
+// importCSV imports the old buckets.csv and <bucket>/objects.csv layout into
+// the metadata store in one transaction. The CSV files are moved under
+// _meta/csv-backup afterwards.
+func importCSV(directory string, store MetaStore) error {
+	bucketsCSV := filepath.Join(directory, "buckets.csv")
+	buckets, err := readCSV(bucketsCSV)
 	if err != nil {
 		return err
 	}
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-
-	var updatedRecords [][]string
-	for _, record := range records {
-		if len(record) > 0 && record[0] != "Name" && record[0] == bucketName {
-			newRecord := []string{record[0], record[1]}
-			newRecord = append(newRecord, time.Now().Format("2006/01/02 15:04:05"))
-			newRecord = append(newRecord, status)
-			updatedRecords = append(updatedRecords, newRecord)
+	tx := &Tx{}
+	var names []string
+	for i, record := range buckets {
+		if i == 0 || len(record) < 4 {
 			continue
 		}
-		updatedRecords = append(updatedRecords, record)
-	}
-	if file.Truncate(0) != nil {
-		return err
-	}
-	if _, err = file.Seek(0, 0); err != nil {
-		return err
-	}
-
-	writer := csv.NewWriter(file)
-	err = writer.WriteAll(updatedRecords)
-	if err != nil {
-		return err
+		tx.PutBucket(Bucket{
+			Name:             record[0],
+			DateOfCreation:   record[1],
+			LastModifiedTime: record[2],
+			Status:           bucketActive, // the old column tracked emptiness
+		})
+		names = append(names, record[0])
+
+		objects, err := readCSV(filepath.Join(directory, record[0], "objects.csv"))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for j, o := range objects {
+			if j == 0 || len(o) < 4 {
+				continue
+			}
+			tx.PutObject(record[0], ObjectMD{
+				ObjectKey:    o[0],
+				Size:         o[1],
+				ContentType:  o[2],
+				LastModified: o[3],
+			})
+		}
 	}
-	return nil
-}
-
-func writeObjectCSV(bucketName string, o ObjectMD) error {
-	path := "/" + bucketName + "/objects.csv"
-	file, err := os.OpenFile(config.Directory+path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
-	if err != nil {
+	if err = store.Commit(tx); err != nil {
 		return err
 	}
-	defer file.Close()
 
-	writer := csv.NewWriter(file)
-	defer writer.Flush()
-
-	if fileInfo, err := os.Stat(file.Name()); err != nil {
-		return err
-	} else if fileInfo.Size() == 0 {
-		writer.Write([]string{"ObjectKey", "Size", "ContentType", "LastModified"})
-	}
-	is, err := elementExists(path, o.ObjectKey)
-	if err != nil {
-		return err
-	}
-	if is {
-		deleteRecord(path, o.ObjectKey)
+	backup := filepath.Join(directory, metaDirName, "csv-backup")
+	for _, name := range names {
+		from := filepath.Join(directory, name, "objects.csv")
+		if _, err := os.Stat(from); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Join(backup, name), 0o755); err != nil {
+			return err
+		}
+		if err := os.Rename(from, filepath.Join(backup, name, "objects.csv")); err != nil {
+			return err
+		}
 	}
-	err = writer.Write([]string{o.ObjectKey, o.Size, o.ContentType, o.LastModified})
-	if err != nil {
+	if err = os.MkdirAll(backup, 0o755); err != nil {
 		return err
 	}
-	return nil
+	return os.Rename(bucketsCSV, filepath.Join(backup, "buckets.csv"))
 }
 
 func readCSV(path string) ([][]string, error) {
-	file, err := os.OpenFile(config.Directory+path, os.O_CREATE, 0o644)
+	file, err := os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 	defer file.Close()
 
 	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = -1
 	records, err := reader.ReadAll()
 	if err != nil {
 		return nil, err
@@ -110,66 +112,3 @@ func readCSV(path string) ([][]string, error) {
 
 	return records, nil
 }
-
-func deleteRecord(path, target string) error {
-	file, err := os.OpenFile(config.Directory+path, os.O_RDWR, 0o644)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
-
-	var updatedRecords [][]string
-	for _, record := range records {
-		if len(record) > 0 && record[0] == target {
-			continue
-		}
-		updatedRecords = append(updatedRecords, record)
-	}
-
-	if file.Truncate(0) != nil {
-		return err
-	}
-	if _, err = file.Seek(0, 0); err != nil {
-		return err
-	}
-
-	writer := csv.NewWriter(file)
-	err = writer.WriteAll(updatedRecords)
-	if err != nil {
-		return err
-	}
-	return nil
-}
-
-func elementExists(path, element string) (bool, error) {
-	records, err := readCSV(path)
-	if err != nil {
-		return false, err
-	}
-	for _, row := range records {
-		for _, bucket := range row {
-			if bucket == element {
-				return true, nil
-			}
-		}
-	}
-	return false, nil
-}
-
-func bucketForDeletion(path, bucketName string) (bool, error) {
-	records, err := readCSV(path)
-	if err != nil {
-		return false, err
-	}
-	for _, record := range records {
-		if record[0] == bucketName {
-			if record[3] == "MarkedForDeletion" {
-				return true, nil
-			}
-		}
-	}
-	return false, nil
-}
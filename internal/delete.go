@@ -0,0 +1,137 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	maxDeleteObjects = 1000
+	maxDeleteBody    = 2 << 20
+)
+
+// deleteObjects answers POST /{bucket}?delete, removing up to 1000 keys or
+// versions in a single metadata transaction.
+func (s *Server) deleteObjects(w http.ResponseWriter, r *http.Request, bucketName string) {
+	contentMD5, err := readContentMD5(r)
+	if err != nil {
+		writeXMLError(w, "InvalidDigest", err.Error())
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxDeleteBody+1))
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	if contentMD5 != nil {
+		if sum := md5.Sum(body); !bytes.Equal(sum[:], contentMD5) {
+			writeXMLError(w, "BadDigest", errBadDigest.Error())
+			return
+		}
+	}
+	var request Delete
+	if len(body) > maxDeleteBody || xml.Unmarshal(body, &request) != nil ||
+		len(request.Objects) == 0 || len(request.Objects) > maxDeleteObjects {
+		writeXMLError(w, "MalformedXML", "")
+		return
+	}
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	if !checkBucketWritable(w, bucket) {
+		return
+	}
+
+	// every object of the request, in name order, then the bucket metadata
+	keys := make([]string, 0, len(request.Objects))
+	for _, object := range request.Objects {
+		if validateObjectKey(object.Key) == nil && object.Key != "" {
+			keys = append(keys, objectLockKey(bucketName, object.Key))
+		}
+	}
+	sort.Strings(keys)
+	for i, key := range keys {
+		if i == 0 || key != keys[i-1] {
+			defer s.locks.lock(key)()
+		}
+	}
+	unlockMeta := s.locks.lock(bucketMetaLockKey(bucketName))
+	bucket, _ = s.store.Bucket(bucketName)
+
+	// plan every deletion against the state before the transaction
+	now := time.Now().Format(timeFormat)
+	tx := &Tx{}
+	var result DeleteResult
+	var removed []ObjectMD
+	gone := make(map[string]bool)
+	for _, object := range request.Objects {
+		if err := validateObjectKey(object.Key); err != nil || object.Key == "" {
+			message := "Object key cannot be empty."
+			if err != nil {
+				message = err.Error()
+			}
+			result.Errors = append(result.Errors, DeleteError{Key: object.Key, VersionId: object.VersionId, Code: "InvalidArgument", Message: message})
+			continue
+		}
+
+		switch {
+		case object.VersionId != "":
+			deleted := DeletedObject{Key: object.Key, VersionId: object.VersionId}
+			for _, v := range s.store.Versions(bucketName, object.Key) {
+				if sameVersion(v.VersionID, object.VersionId) {
+					tx.DeleteVersion(bucketName, object.Key, v.VersionID)
+					removed = append(removed, v)
+					if v.DeleteMarker {
+						deleted.DeleteMarker = true
+						deleted.DeleteMarkerVersionId = displayVersionID(v.VersionID)
+					}
+					break
+				}
+			}
+			result.Deleted = append(result.Deleted, deleted)
+		case bucket.Versioning != "":
+			versionID, err := nextVersionID(bucket)
+			if err != nil {
+				result.Errors = append(result.Errors, DeleteError{Key: object.Key, Code: "InternalError", Message: err.Error()})
+				continue
+			}
+			if v, ok := s.addDeleteMarker(tx, bucketName, object.Key, versionID, now); ok {
+				removed = append(removed, v)
+			}
+			result.Deleted = append(result.Deleted, DeletedObject{Key: object.Key, DeleteMarker: true, DeleteMarkerVersionId: versionID})
+		default:
+			// a key that does not exist counts as deleted, like in S3
+			if o, ok := s.store.Object(bucketName, object.Key); ok && !gone[object.Key] {
+				tx.DeleteObject(bucketName, object.Key)
+				removed = append(removed, o)
+				gone[object.Key] = true
+			}
+			result.Deleted = append(result.Deleted, DeletedObject{Key: object.Key})
+		}
+	}
+	err = s.store.Commit(tx)
+	unlockMeta()
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+
+	for _, o := range removed {
+		if err := s.removeObjectData(bucketName, o); err != nil {
+			result.Errors = append(result.Errors, DeleteError{Key: o.ObjectKey, Code: "InternalError", Message: err.Error()})
+		}
+	}
+	if request.Quiet {
+		result.Deleted = nil
+	}
+	writeXML(w, result, http.StatusOK)
+}
@@ -0,0 +1,251 @@
+package internal
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// forceDeleteBatch is how many keys a force delete removes per transaction.
+const forceDeleteBatch = 100
+
+const (
+	deletionInProgress = "InProgress"
+	deletionCompleted  = "Completed"
+	deletionFailed     = "Failed"
+)
+
+var errShuttingDown = errors.New("The server is shutting down.")
+
+// bucketDeletions tracks the force deletes started since the server came
+// up, by bucket name. Finished ones stay so their outcome can still be read.
+// The running ones are also in running, closed when they return.
+type bucketDeletions struct {
+	mu      sync.Mutex
+	jobs    map[string]*BucketDeletion
+	running map[string]chan struct{}
+	stop    chan struct{}
+	stopped bool
+}
+
+func newBucketDeletions() *bucketDeletions {
+	return &bucketDeletions{
+		jobs:    make(map[string]*BucketDeletion),
+		running: make(map[string]chan struct{}),
+		stop:    make(chan struct{}),
+	}
+}
+
+// forceDeleteBucket answers DELETE /{bucket}?force. The bucket stops taking
+// writes at once and its contents are removed in the background; the reply
+// is the progress report that GET /{bucket}?force keeps returning.
+func (s *Server) forceDeleteBucket(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !s.isAdmin(r) {
+		writeXMLError(w, "AccessDenied", "Force delete needs an admin access key.")
+		return
+	}
+
+	unlock := s.locks.lock(bucketLockKey(bucketName))
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		unlock()
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	if bucket.Status != bucketDeleting {
+		bucket.Status = bucketDeleting
+		bucket.LastModifiedTime = time.Now().Format(timeFormat)
+		tx := &Tx{}
+		tx.PutBucket(bucket)
+		if err := s.store.Commit(tx); err != nil {
+			unlock()
+			writeXMLError(w, "InternalError", err.Error())
+			return
+		}
+	}
+	s.startBucketDeletion(bucketName)
+	unlock()
+
+	report, _ := s.bucketDeletionReport(bucketName)
+	writeXML(w, report, http.StatusAccepted)
+}
+
+// getBucketDeletion answers GET /{bucket}?force with the progress of a
+// force delete.
+func (s *Server) getBucketDeletion(w http.ResponseWriter, r *http.Request, bucketName string) {
+	if !s.isAdmin(r) {
+		writeXMLError(w, "AccessDenied", "Force delete needs an admin access key.")
+		return
+	}
+	report, ok := s.bucketDeletionReport(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "No force delete was started for this bucket.")
+		return
+	}
+	writeXML(w, report, http.StatusOK)
+}
+
+// ResumeBucketDeletions restarts the force deletes that were cut short by
+// the last shutdown.
+func (s *Server) ResumeBucketDeletions() {
+	for _, bucket := range s.store.Buckets() {
+		if bucket.Status == bucketDeleting {
+			log.Printf("resuming the deletion of bucket %s\n", bucket.Name)
+			s.startBucketDeletion(bucket.Name)
+		}
+	}
+}
+
+// startBucketDeletion runs a force delete of bucketName unless one is
+// already running.
+func (s *Server) startBucketDeletion(bucketName string) {
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+	if s.deletions.stopped {
+		return
+	}
+	if job, ok := s.deletions.jobs[bucketName]; ok && job.Status == deletionInProgress {
+		return
+	}
+	job := &BucketDeletion{
+		Bucket:  bucketName,
+		Status:  deletionInProgress,
+		Started: time.Now().UTC().Format(isoTimeFormat),
+	}
+	done := make(chan struct{})
+	s.deletions.jobs[bucketName] = job
+	s.deletions.running[bucketName] = done
+	go func() {
+		s.runBucketDeletion(job)
+		s.deletions.mu.Lock()
+		delete(s.deletions.running, bucketName)
+		s.deletions.mu.Unlock()
+		close(done)
+	}()
+}
+
+// StopBucketDeletions asks the running force deletes to stop after their
+// current batch and waits for them until ctx is done. The buckets stay
+// marked, so the deletions resume on the next start.
+func (s *Server) StopBucketDeletions(ctx context.Context) error {
+	s.deletions.mu.Lock()
+	if !s.deletions.stopped {
+		s.deletions.stopped = true
+		close(s.deletions.stop)
+	}
+	running := make([]chan struct{}, 0, len(s.deletions.running))
+	for _, done := range s.deletions.running {
+		running = append(running, done)
+	}
+	s.deletions.mu.Unlock()
+
+	for _, done := range running {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// bucketDeletionReport returns a copy of the progress of a force delete.
+func (s *Server) bucketDeletionReport(bucketName string) (BucketDeletion, bool) {
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+	job, ok := s.deletions.jobs[bucketName]
+	if !ok {
+		return BucketDeletion{}, false
+	}
+	report := *job
+	if report.Status == deletionInProgress {
+		report.KeysRemaining = s.store.KeyCount(bucketName)
+	}
+	return report, true
+}
+
+func (s *Server) runBucketDeletion(job *BucketDeletion) {
+	err := s.drainBucket(job)
+	if err == nil {
+		// writes that were under way when the bucket was marked may have
+		// added keys; nothing else gets in while the bucket lock is held
+		unlock := s.locks.lock(bucketLockKey(job.Bucket))
+		if err = s.drainBucket(job); err == nil {
+			err = s.removeBucket(job.Bucket)
+		}
+		unlock()
+	}
+	if err == errShuttingDown {
+		log.Printf("deletion of bucket %s stopped, it resumes on the next start\n", job.Bucket)
+		return
+	}
+
+	s.deletions.mu.Lock()
+	defer s.deletions.mu.Unlock()
+	job.Finished = time.Now().UTC().Format(isoTimeFormat)
+	if err != nil {
+		log.Printf("deleting bucket %s: %v\n", job.Bucket, err)
+		job.Status = deletionFailed
+		job.Error = err.Error()
+		job.KeysRemaining = s.store.KeyCount(job.Bucket)
+		return
+	}
+	job.Status = deletionCompleted
+}
+
+// drainBucket removes every version and delete marker of the bucket of job,
+// a batch of keys at a time.
+func (s *Server) drainBucket(job *BucketDeletion) error {
+	for {
+		select {
+		case <-s.deletions.stop:
+			return errShuttingDown
+		default:
+		}
+		var keys []string
+		s.store.WalkVersions(job.Bucket, "", func(key string, _ []ObjectMD) bool {
+			keys = append(keys, key)
+			return len(keys) < forceDeleteBatch
+		})
+		if len(keys) == 0 {
+			return nil
+		}
+		if err := s.deleteKeys(job.Bucket, keys); err != nil {
+			return err
+		}
+		s.deletions.mu.Lock()
+		job.KeysDeleted += len(keys)
+		s.deletions.mu.Unlock()
+	}
+}
+
+// deleteKeys removes every version of keys, which are in name order, in a
+// single transaction.
+func (s *Server) deleteKeys(bucketName string, keys []string) error {
+	for _, key := range keys {
+		defer s.locks.lock(objectLockKey(bucketName, key))()
+	}
+	unlockMeta := s.locks.lock(bucketMetaLockKey(bucketName))
+	tx := &Tx{}
+	var removed []ObjectMD
+	for _, key := range keys {
+		for _, v := range s.store.Versions(bucketName, key) {
+			tx.DeleteVersion(bucketName, key, v.VersionID)
+			removed = append(removed, v)
+		}
+	}
+	err := s.store.Commit(tx)
+	unlockMeta()
+	if err != nil {
+		return err
+	}
+	for _, o := range removed {
+		if err := s.removeObjectData(bucketName, o); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+package internal
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const maxListKeys = 1000
+
+// continuation tokens are opaque to clients: a base64 encoded marker telling
+// whether the page ended on a key or on a common prefix.
+const (
+	tokenKey    = "k:"
+	tokenPrefix = "p:"
+)
+
+// listObjects answers ListObjectsV2 for a bucket.
+func (s *Server) listObjects(w http.ResponseWriter, r *http.Request, bucketName string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	startAfter := query.Get("start-after")
+	encodingType := query.Get("encoding-type")
+	if encodingType != "" && encodingType != "url" {
+		writeXMLError(w, "InvalidArgument", "Invalid encoding-type.")
+		return
+	}
+
+	maxKeys := maxListKeys
+	if value := query.Get("max-keys"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			writeXMLError(w, "InvalidArgument", "max-keys must be a non-negative integer.")
+			return
+		}
+		maxKeys = min(n, maxListKeys)
+	}
+
+	// where the previous page stopped
+	after := startAfter
+	skipPrefix := ""
+	token := query.Get("continuation-token")
+	if query.Has("continuation-token") {
+		marker, err := base64.RawURLEncoding.DecodeString(token)
+		if err != nil || len(marker) < len(tokenKey) {
+			writeXMLError(w, "InvalidArgument", "The continuation token is not valid.")
+			return
+		}
+		after = string(marker[len(tokenKey):])
+		if strings.HasPrefix(string(marker), tokenPrefix) {
+			skipPrefix = after
+		}
+	}
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+
+	result := ListBucketResult{
+		Name:              bucketName,
+		Prefix:            prefix,
+		Delimiter:         delimiter,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+		StartAfter:        startAfter,
+		EncodingType:      encodingType,
+	}
+	lastMarker := ""
+	s.store.WalkObjects(bucketName, max(after, prefix), func(o ObjectMD) bool {
+		if o.ObjectKey == after {
+			return true
+		}
+		if !strings.HasPrefix(o.ObjectKey, prefix) {
+			// keys are sorted, nothing after this one can match
+			return false
+		}
+		if delimiter != "" {
+			if i := strings.Index(o.ObjectKey[len(prefix):], delimiter); i >= 0 {
+				common := o.ObjectKey[:len(prefix)+i+len(delimiter)]
+				if common == skipPrefix {
+					return true
+				}
+				if result.KeyCount == maxKeys {
+					result.IsTruncated = true
+					return false
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{Prefix: encodeKey(common, encodingType)})
+				result.KeyCount++
+				skipPrefix = common
+				lastMarker = tokenPrefix + common
+				return true
+			}
+		}
+		if result.KeyCount == maxKeys {
+			result.IsTruncated = true
+			return false
+		}
+		result.Contents = append(result.Contents, Contents{
+			Key:          encodeKey(o.ObjectKey, encodingType),
+			LastModified: parseTime(o.LastModified).UTC().Format(isoTimeFormat),
+			ETag:         quoteETag(o.ETag),
+			Size:         o.Size,
+			StorageClass: "STANDARD",
+		})
+		result.KeyCount++
+		lastMarker = tokenKey + o.ObjectKey
+		return true
+	})
+	// with max-keys=0 nothing was returned, so there is no token to carry on
+	// from and the listing cannot be reported as truncated
+	if lastMarker == "" {
+		result.IsTruncated = false
+	}
+	if result.IsTruncated {
+		result.NextContinuationToken = base64.RawURLEncoding.EncodeToString([]byte(lastMarker))
+	}
+
+	result.Prefix = encodeKey(prefix, encodingType)
+	result.Delimiter = encodeKey(delimiter, encodingType)
+	result.StartAfter = encodeKey(startAfter, encodingType)
+	writeXML(w, result, http.StatusOK)
+}
+
+func encodeKey(key, encodingType string) string {
+	if encodingType == "url" {
+		return url.QueryEscape(key)
+	}
+	return key
+}
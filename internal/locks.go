@@ -0,0 +1,81 @@
+package internal
+
+import "sync"
+
+// lockManager hands out reader/writer locks by name. Entries exist only
+// while somebody holds or waits for them.
+//
+// Locks are always taken in the order bucket, object, multipart upload,
+// upload part, bucket metadata. Two locks of the same kind, as a copy between
+// objects needs, are taken in name order.
+type lockManager struct {
+	mu    sync.Mutex
+	locks map[string]*namedLock
+}
+
+type namedLock struct {
+	sync.RWMutex
+	refs int
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{locks: make(map[string]*namedLock)}
+}
+
+func (m *lockManager) get(name string) *namedLock {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l, ok := m.locks[name]
+	if !ok {
+		l = &namedLock{}
+		m.locks[name] = l
+	}
+	l.refs++
+	return l
+}
+
+func (m *lockManager) put(name string, l *namedLock) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	l.refs--
+	if l.refs == 0 {
+		delete(m.locks, name)
+	}
+}
+
+// lock takes name exclusively and returns its unlock function.
+func (m *lockManager) lock(name string) func() {
+	l := m.get(name)
+	l.Lock()
+	return func() {
+		l.Unlock()
+		m.put(name, l)
+	}
+}
+
+// rlock takes name shared and returns its unlock function.
+func (m *lockManager) rlock(name string) func() {
+	l := m.get(name)
+	l.RLock()
+	return func() {
+		l.RUnlock()
+		m.put(name, l)
+	}
+}
+
+// bucketLockKey guards the existence of a bucket: object handlers hold it
+// shared, bucket creation and deletion hold it exclusively.
+func bucketLockKey(bucketName string) string {
+	return bucketName
+}
+
+// objectLockKey guards the data file and metadata of a single object.
+func objectLockKey(bucketName, objectKey string) string {
+	return bucketName + "/" + objectKey
+}
+
+// bucketMetaLockKey serializes read-modify-write updates of a bucket's own
+// metadata record made by object handlers.
+func bucketMetaLockKey(bucketName string) string {
+	return "meta:" + bucketName
+}
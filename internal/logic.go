@@ -2,8 +2,16 @@ package internal
 
 import (
 	"errors"
-	"os"
+	"net/http"
 	"regexp"
+	"unicode/utf8"
+)
+
+const maxObjectKeyLength = 1024
+
+var (
+	errKeyTooLong = errors.New("Your key is too long, object keys are limited to 1024 bytes.")
+	errKeyInvalid = errors.New("Object keys must be valid UTF-8.")
 )
 
 func validateBucketName(name string) error {
@@ -26,9 +34,39 @@ func validateBucketName(name string) error {
 		return errors.New("Bucket must not contain two consecutive periods or dashes.")
 	}
 
+	if isLegacyPrefix(name) {
+		return errors.New("Bucket name is reserved for the /put/, /get/ and /delete/ routes.")
+	}
+
+	if name == metricsBucketName {
+		return errors.New("Bucket name is reserved for the /metrics endpoint.")
+	}
+
 	return nil
 }
 
+// validateObjectKey applies the S3 key rules. Keys never reach the file
+// system as paths, see keyFileName, so "..", "/" or the names of the
+// server's own files are ordinary keys.
+func validateObjectKey(key string) error {
+	if len(key) > maxObjectKeyLength {
+		return errKeyTooLong
+	}
+	if !utf8.ValidString(key) {
+		return errKeyInvalid
+	}
+	return nil
+}
+
+// writeKeyError reports a key validateObjectKey rejected.
+func writeKeyError(w http.ResponseWriter, err error) {
+	if err == errKeyTooLong {
+		writeXMLError(w, "KeyTooLongError", err.Error())
+		return
+	}
+	writeXMLError(w, "InvalidArgument", err.Error())
+}
+
 func checkConsecutive(str string) bool {
 	for i := 0; i < len(str)-1; i++ {
 		if str[i] == '.' && str[i+1] == '.' {
@@ -41,13 +79,8 @@ func checkConsecutive(str string) bool {
 	return false
 }
 
-func isBucketEmpty(path string) (bool, error) {
-	dirEntries, err := os.ReadDir(path)
-	if err != nil {
-		return false, err
-	}
-
-	return len(dirEntries) == 0, nil
+func isLegacyPrefix(name string) bool {
+	return name == "put" || name == "get" || name == "delete"
 }
 
 func isStandardPackage(packageName string) bool {
@@ -0,0 +1,86 @@
+package internal
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+)
+
+const userMetadataPrefix = "X-Amz-Meta-"
+
+// maxUserMetadataSize is the S3 limit on the names and values of all
+// x-amz-meta-* headers of an object together.
+const maxUserMetadataSize = 2 << 10
+
+var errMetadataTooLarge = errors.New("Your metadata headers exceed the maximum allowed metadata size.")
+
+// ObjectHeaders are the headers a client sets on upload and gets back on
+// every GET and HEAD of the object.
+type ObjectHeaders struct {
+	ContentEncoding    string            `json:",omitempty"`
+	ContentDisposition string            `json:",omitempty"`
+	CacheControl       string            `json:",omitempty"`
+	ContentLanguage    string            `json:",omitempty"`
+	Expires            string            `json:",omitempty"`
+	Metadata           map[string]string `json:",omitempty"` // x-amz-meta-* by lower-case name without the prefix
+}
+
+// readObjectHeaders collects the headers of an upload that are stored with
+// the object.
+func readObjectHeaders(r *http.Request) (ObjectHeaders, error) {
+	h := ObjectHeaders{
+		ContentEncoding:    contentEncoding(r),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+		Expires:            r.Header.Get("Expires"),
+	}
+	size := 0
+	for name, values := range r.Header {
+		if !strings.HasPrefix(name, userMetadataPrefix) || len(name) == len(userMetadataPrefix) {
+			continue
+		}
+		if h.Metadata == nil {
+			h.Metadata = make(map[string]string)
+		}
+		key := strings.ToLower(name[len(userMetadataPrefix):])
+		value := strings.Join(values, ",")
+		h.Metadata[key] = value
+		size += len(key) + len(value)
+	}
+	if size > maxUserMetadataSize {
+		return ObjectHeaders{}, errMetadataTooLarge
+	}
+	return h, nil
+}
+
+// contentEncoding is the Content-Encoding of the object itself, without the
+// aws-chunked transfer encoding of the request body.
+func contentEncoding(r *http.Request) string {
+	var encodings []string
+	for _, value := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		value = strings.TrimSpace(value)
+		if value != "" && value != "aws-chunked" {
+			encodings = append(encodings, value)
+		}
+	}
+	return strings.Join(encodings, ",")
+}
+
+// setObjectMetadata replays the stored upload headers in a response.
+func setObjectMetadata(w http.ResponseWriter, h ObjectHeaders) {
+	for name, value := range map[string]string{
+		"Content-Encoding":    h.ContentEncoding,
+		"Content-Disposition": h.ContentDisposition,
+		"Cache-Control":       h.CacheControl,
+		"Content-Language":    h.ContentLanguage,
+		"Expires":             h.Expires,
+	} {
+		if value != "" {
+			w.Header().Set(name, value)
+		}
+	}
+	for key, value := range h.Metadata {
+		w.Header().Set(userMetadataPrefix+key, value)
+	}
+}
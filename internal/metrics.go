@@ -0,0 +1,324 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// metricsPath serves the Prometheus text exposition format, outside
+// authentication so scrapers need no signature.
+const (
+	metricsPath       = "/metrics"
+	metricsBucketName = "metrics"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration
+// histogram.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// requestSeries is what is known about the requests sharing an operation,
+// status and error code.
+type requestSeries struct {
+	count    uint64
+	buckets  []uint64 // per durationBuckets, not cumulative
+	seconds  float64
+	bytesIn  int64
+	bytesOut int64
+}
+
+type seriesKey struct {
+	operation string
+	status    int
+	errorCode string // empty for a success
+}
+
+// requestMetrics holds the series of the requests a server has served.
+type requestMetrics struct {
+	mu     sync.Mutex
+	series map[seriesKey]*requestSeries
+}
+
+func newRequestMetrics() *requestMetrics {
+	return &requestMetrics{series: make(map[seriesKey]*requestSeries)}
+}
+
+// observe adds one finished request to the metrics.
+func (m *requestMetrics) observe(key seriesKey, duration time.Duration, bytesIn, bytesOut int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	s, ok := m.series[key]
+	if !ok {
+		s = &requestSeries{buckets: make([]uint64, len(durationBuckets))}
+		m.series[key] = s
+	}
+	seconds := duration.Seconds()
+	s.count++
+	s.seconds += seconds
+	s.bytesIn += bytesIn
+	s.bytesOut += bytesOut
+	if i := sort.SearchFloat64s(durationBuckets, seconds); i < len(durationBuckets) {
+		s.buckets[i]++
+	}
+}
+
+// responseRecorder remembers what a handler answered: the status, the body
+// size and the S3 error code passed to writeXMLError, along with the ID of
+// the request, the resource it named and the access key it was signed with.
+type responseRecorder struct {
+	http.ResponseWriter
+	status    int
+	bytes     int64
+	errorCode string
+	requestID string
+	resource  string
+	accessKey string
+}
+
+func (w *responseRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *responseRecorder) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Unwrap lets http.ResponseController reach the connection.
+func (w *responseRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recordErrorCode notes the error code of a response when w is recording.
+func recordErrorCode(w http.ResponseWriter, code string) {
+	if recorder, ok := w.(*responseRecorder); ok && recorder.errorCode == "" {
+		recorder.errorCode = code
+	}
+}
+
+// recordAccessKey notes who signed a request when w is recording.
+func recordAccessKey(w http.ResponseWriter, accessKey string) {
+	if recorder, ok := w.(*responseRecorder); ok {
+		recorder.accessKey = accessKey
+	}
+}
+
+// countingReader counts the request body bytes a handler reads.
+type countingReader struct {
+	io.ReadCloser
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// instrument measures and logs every request passed to next.
+func (s *Server) instrument(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		operation := operationName(r)
+		recorder := &responseRecorder{ResponseWriter: w, requestID: newRequestID(), resource: r.URL.Path}
+		setRequestIDHeaders(w, recorder.requestID)
+		body := &countingReader{ReadCloser: r.Body}
+		r.Body = body
+		next.ServeHTTP(recorder, r)
+
+		if recorder.status == 0 {
+			recorder.status = http.StatusOK
+		}
+		key := seriesKey{operation: operation, status: recorder.status, errorCode: recorder.errorCode}
+		s.metrics.observe(key, time.Since(start), body.n, recorder.bytes)
+		s.logAccess(r, recorder, operation, start, body.n)
+	})
+}
+
+// operationName names the S3 operation a request asks for, following the
+// dispatch of route and s3Handler.
+func operationName(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	prefix, rest, _ := strings.Cut(path, "/")
+	if isLegacyPrefix(prefix) && strings.HasPrefix(r.URL.Path, "/"+prefix+"/") {
+		bucketName, objectKey, _ := strings.Cut(rest, "/")
+		switch {
+		case prefix == "put" && objectKey == "":
+			return "CreateBucket"
+		case prefix == "put":
+			return "PutObject"
+		case prefix == "get" && bucketName == "":
+			return "ListBuckets"
+		case prefix == "get" && objectKey == "":
+			return "ListObjects"
+		case prefix == "get":
+			return "GetObject"
+		case objectKey == "":
+			return "DeleteBucket"
+		}
+		return "DeleteObject"
+	}
+
+	bucketName, objectKey, _ := strings.Cut(path, "/")
+	query := r.URL.Query()
+	copySource := r.Header.Get("X-Amz-Copy-Source") != ""
+	switch {
+	case bucketName == "" && r.Method == http.MethodGet:
+		return "ListBuckets"
+	case bucketName == "":
+		return "Unknown"
+	case objectKey == "":
+		switch {
+		case r.Method == http.MethodPut && query.Has("versioning"):
+			return "PutBucketVersioning"
+		case r.Method == http.MethodPut:
+			return "CreateBucket"
+		case r.Method == http.MethodDelete && query.Has("force"):
+			return "ForceDeleteBucket"
+		case r.Method == http.MethodDelete:
+			return "DeleteBucket"
+		case r.Method == http.MethodGet && query.Has("force"):
+			return "GetBucketDeletion"
+		case r.Method == http.MethodGet && query.Has("versioning"):
+			return "GetBucketVersioning"
+		case r.Method == http.MethodGet && query.Has("versions"):
+			return "ListObjectVersions"
+		case r.Method == http.MethodGet && query.Has("uploads"):
+			return "ListMultipartUploads"
+		case r.Method == http.MethodGet:
+			return "ListObjects"
+		case r.Method == http.MethodHead:
+			return "HeadBucket"
+		case r.Method == http.MethodPost && query.Has("delete"):
+			return "DeleteObjects"
+		}
+	case r.Method == http.MethodPut && query.Has("uploadId") && copySource:
+		return "UploadPartCopy"
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		return "UploadPart"
+	case r.Method == http.MethodPut && copySource:
+		return "CopyObject"
+	case r.Method == http.MethodPut:
+		return "PutObject"
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		return "ListParts"
+	case r.Method == http.MethodGet:
+		return "GetObject"
+	case r.Method == http.MethodHead:
+		return "HeadObject"
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		return "CreateMultipartUpload"
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		return "CompleteMultipartUpload"
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		return "AbortMultipartUpload"
+	case r.Method == http.MethodDelete:
+		return "DeleteObject"
+	}
+	return "Unknown"
+}
+
+// serveMetrics answers GET /metrics.
+func (s *Server) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "only GET is allowed on /metrics", http.StatusMethodNotAllowed)
+		return
+	}
+	var b strings.Builder
+	s.metrics.write(&b)
+	s.writeStoreMetrics(&b)
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	io.WriteString(w, b.String())
+}
+
+func (m *requestMetrics) write(b *strings.Builder) {
+	m.mu.Lock()
+	keys := make([]seriesKey, 0, len(m.series))
+	series := make(map[seriesKey]requestSeries, len(m.series))
+	for key, s := range m.series {
+		keys = append(keys, key)
+		copied := *s
+		copied.buckets = append([]uint64(nil), s.buckets...)
+		series[key] = copied
+	}
+	m.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].operation != keys[j].operation {
+			return keys[i].operation < keys[j].operation
+		}
+		if keys[i].status != keys[j].status {
+			return keys[i].status < keys[j].status
+		}
+		return keys[i].errorCode < keys[j].errorCode
+	})
+	labels := func(key seriesKey) string {
+		return "operation=" + labelValue(key.operation) +
+			",status=" + labelValue(strconv.Itoa(key.status)) +
+			",error_code=" + labelValue(key.errorCode)
+	}
+
+	writeMetricHeader(b, "triples_requests_total", "counter", "Requests served, by S3 operation, HTTP status and S3 error code.")
+	for _, key := range keys {
+		fmt.Fprintf(b, "triples_requests_total{%s} %d\n", labels(key), series[key].count)
+	}
+	writeMetricHeader(b, "triples_request_duration_seconds", "histogram", "Time to serve a request, by S3 operation, HTTP status and S3 error code.")
+	for _, key := range keys {
+		s := series[key]
+		var cumulative uint64
+		for i, bound := range durationBuckets {
+			cumulative += s.buckets[i]
+			fmt.Fprintf(b, "triples_request_duration_seconds_bucket{%s,le=%s} %d\n", labels(key), labelValue(formatFloat(bound)), cumulative)
+		}
+		fmt.Fprintf(b, "triples_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", labels(key), s.count)
+		fmt.Fprintf(b, "triples_request_duration_seconds_sum{%s} %s\n", labels(key), formatFloat(s.seconds))
+		fmt.Fprintf(b, "triples_request_duration_seconds_count{%s} %d\n", labels(key), s.count)
+	}
+	writeMetricHeader(b, "triples_request_bytes_total", "counter", "Request body bytes read, by S3 operation, HTTP status and S3 error code.")
+	for _, key := range keys {
+		fmt.Fprintf(b, "triples_request_bytes_total{%s} %d\n", labels(key), series[key].bytesIn)
+	}
+	writeMetricHeader(b, "triples_response_bytes_total", "counter", "Response body bytes sent, by S3 operation, HTTP status and S3 error code.")
+	for _, key := range keys {
+		fmt.Fprintf(b, "triples_response_bytes_total{%s} %d\n", labels(key), series[key].bytesOut)
+	}
+}
+
+func (s *Server) writeStoreMetrics(b *strings.Builder) {
+	stats := s.store.Stats()
+	writeMetricHeader(b, "triples_buckets", "gauge", "Buckets in the metadata store.")
+	fmt.Fprintf(b, "triples_buckets %d\n", stats.Buckets)
+	writeMetricHeader(b, "triples_objects", "gauge", "Current objects, older versions not counted.")
+	fmt.Fprintf(b, "triples_objects %d\n", stats.Objects)
+	writeMetricHeader(b, "triples_object_versions", "gauge", "Object versions kept, current ones included.")
+	fmt.Fprintf(b, "triples_object_versions %d\n", stats.Versions)
+	writeMetricHeader(b, "triples_stored_bytes", "gauge", "Size of every object version kept.")
+	fmt.Fprintf(b, "triples_stored_bytes %d\n", stats.Bytes)
+}
+
+func writeMetricHeader(b *strings.Builder, name, kind, help string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// labelValue quotes a label value as the text format wants it.
+func labelValue(value string) string {
+	value = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+	return `"` + value + `"`
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
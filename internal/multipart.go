@@ -0,0 +1,418 @@
+package internal
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// multipartDirName holds the parts of uploads in progress inside a bucket.
+const multipartDirName = ".multipart"
+
+const (
+	maxPartNumber  = 10000
+	minPartSize    = 5 << 20
+	maxListParts   = 1000
+	maxListUploads = 1000
+)
+
+type Upload struct {
+	UploadID    string
+	ObjectKey   string
+	ContentType string
+	Initiated   string
+	Parts       []Part // sorted by PartNumber
+	ObjectHeaders
+}
+
+type Part struct {
+	PartNumber   int
+	ETag         string
+	Size         int64
+	LastModified string
+	DataName     string `json:",omitempty"` // of the data file, see partPath
+}
+
+func (s *Server) uploadDir(bucketName, uploadID string) string {
+	return filepath.Join(s.directory, bucketName, multipartDirName, uploadID)
+}
+
+// partPath is where the data of p lives. Like objects, every upload of a
+// part gets a file of its own.
+func (s *Server) partPath(bucketName, uploadID string, p Part) string {
+	return withDataName(filepath.Join(s.uploadDir(bucketName, uploadID), strconv.Itoa(p.PartNumber)), p.DataName)
+}
+
+// uploadLockKey is held shared by part uploads and exclusively by
+// completion and abort.
+func uploadLockKey(bucketName, uploadID string) string {
+	return "upload:" + bucketName + "/" + uploadID
+}
+
+func partLockKey(bucketName, uploadID string, partNumber int) string {
+	return uploadLockKey(bucketName, uploadID) + "#" + strconv.Itoa(partNumber)
+}
+
+func newUploadID() (string, error) {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(id), nil
+}
+
+// createMultipartUpload answers POST /{bucket}/{key}?uploads.
+func (s *Server) createMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	if !checkBucketWritable(w, bucket) {
+		return
+	}
+
+	headers, err := readObjectHeaders(r)
+	if err != nil {
+		writeXMLError(w, "MetadataTooLarge", err.Error())
+		return
+	}
+	uploadID, err := newUploadID()
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	if err = os.MkdirAll(s.uploadDir(bucketName, uploadID), 0o755); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	tx := &Tx{}
+	tx.PutUpload(bucketName, Upload{
+		UploadID:      uploadID,
+		ObjectKey:     objectKey,
+		ContentType:   r.Header.Get("Content-Type"),
+		Initiated:     time.Now().Format(timeFormat),
+		ObjectHeaders: headers,
+	})
+	if err = s.store.Commit(tx); err != nil {
+		os.RemoveAll(s.uploadDir(bucketName, uploadID))
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+
+	writeXML(w, InitiateMultipartUploadResult{
+		Bucket:   bucketName,
+		Key:      objectKey,
+		UploadId: uploadID,
+	}, http.StatusOK)
+}
+
+// uploadPart answers PUT /{bucket}/{key}?partNumber=N&uploadId=ID.
+func (s *Server) uploadPart(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	partNumber, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || partNumber < 1 || partNumber > maxPartNumber {
+		writeXMLError(w, "InvalidArgument", "Part number must be an integer between 1 and 10000, inclusive.")
+		return
+	}
+	contentMD5, err := readContentMD5(r)
+	if err != nil {
+		writeXMLError(w, "InvalidDigest", err.Error())
+		return
+	}
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.rlock(uploadLockKey(bucketName, uploadID))()
+	defer s.locks.lock(partLockKey(bucketName, uploadID, partNumber))()
+
+	if bucket, _ := s.store.Bucket(bucketName); !checkBucketWritable(w, bucket) {
+		return
+	}
+	u, ok := s.store.Upload(bucketName, uploadID)
+	if !ok || u.ObjectKey != objectKey {
+		writeXMLError(w, "NoSuchUpload", errNoSuchUpload.Error())
+		return
+	}
+
+	part := Part{PartNumber: partNumber}
+	if part.DataName, err = newUploadID(); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	size, etag, err := writeObjectFile(s.partPath(bucketName, uploadID, part), r.Body, contentMD5)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	part.ETag = etag
+	part.Size = size
+	part.LastModified = time.Now().Format(timeFormat)
+	tx := &Tx{}
+	tx.PutPart(bucketName, uploadID, part)
+	if err = s.store.Commit(tx); err != nil {
+		os.Remove(s.partPath(bucketName, uploadID, part))
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	// the part uploaded again is replaced now
+	for _, old := range u.Parts {
+		if old.PartNumber == partNumber {
+			os.Remove(s.partPath(bucketName, uploadID, old))
+		}
+	}
+	w.Header().Set("ETag", quoteETag(etag))
+	w.WriteHeader(http.StatusOK)
+}
+
+// listParts answers GET /{bucket}/{key}?uploadId=ID.
+func (s *Server) listParts(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	query := r.URL.Query()
+	maxParts, err := queryInt(query.Get("max-parts"), maxListParts)
+	if err != nil {
+		writeXMLError(w, "InvalidArgument", "max-parts must be a non-negative integer.")
+		return
+	}
+	maxParts = min(maxParts, maxListParts)
+	marker, err := queryInt(query.Get("part-number-marker"), 0)
+	if err != nil {
+		writeXMLError(w, "InvalidArgument", "part-number-marker must be a non-negative integer.")
+		return
+	}
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	u, ok := s.store.Upload(bucketName, uploadID)
+	if !ok || u.ObjectKey != objectKey {
+		writeXMLError(w, "NoSuchUpload", errNoSuchUpload.Error())
+		return
+	}
+
+	result := ListPartsResult{
+		Bucket:           bucketName,
+		Key:              objectKey,
+		UploadId:         uploadID,
+		PartNumberMarker: marker,
+		MaxParts:         maxParts,
+		StorageClass:     "STANDARD",
+	}
+	for _, p := range u.Parts {
+		if p.PartNumber <= marker {
+			continue
+		}
+		if len(result.Parts) == maxParts {
+			result.IsTruncated = true
+			break
+		}
+		result.Parts = append(result.Parts, PartInfo{
+			PartNumber:   p.PartNumber,
+			LastModified: parseTime(p.LastModified).UTC().Format(isoTimeFormat),
+			ETag:         quoteETag(p.ETag),
+			Size:         p.Size,
+		})
+		result.NextPartNumberMarker = p.PartNumber
+	}
+	// an empty page has no marker to carry on from, see listObjects
+	if len(result.Parts) == 0 {
+		result.IsTruncated = false
+	}
+	writeXML(w, result, http.StatusOK)
+}
+
+// completeMultipartUpload answers POST /{bucket}/{key}?uploadId=ID by joining
+// the listed parts into the object.
+func (s *Server) completeMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	var request CompleteMultipartUpload
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&request); err != nil || len(request.Parts) == 0 {
+		writeXMLError(w, "MalformedXML", "")
+		return
+	}
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.lock(objectLockKey(bucketName, objectKey))()
+	defer s.locks.lock(uploadLockKey(bucketName, uploadID))()
+
+	bucket, _ := s.store.Bucket(bucketName)
+	if !checkBucketWritable(w, bucket) {
+		return
+	}
+	u, ok := s.store.Upload(bucketName, uploadID)
+	if !ok || u.ObjectKey != objectKey {
+		writeXMLError(w, "NoSuchUpload", errNoSuchUpload.Error())
+		return
+	}
+	stored := make(map[int]Part, len(u.Parts))
+	for _, p := range u.Parts {
+		stored[p.PartNumber] = p
+	}
+
+	// check the part list before touching the object
+	var files []io.Reader
+	var sums []byte
+	var size int64
+	var sizes []int64
+	for i, requested := range request.Parts {
+		if i > 0 && requested.PartNumber <= request.Parts[i-1].PartNumber {
+			writeXMLError(w, "InvalidPartOrder", "")
+			return
+		}
+		p, ok := stored[requested.PartNumber]
+		if !ok || strings.Trim(requested.ETag, `"`) != p.ETag {
+			writeXMLError(w, "InvalidPart", "")
+			return
+		}
+		if i < len(request.Parts)-1 && p.Size < minPartSize {
+			writeXMLError(w, "EntityTooSmall", "")
+			return
+		}
+		file, err := os.Open(s.partPath(bucketName, uploadID, p))
+		if err != nil {
+			writeXMLError(w, "InternalError", err.Error())
+			return
+		}
+		defer file.Close()
+		files = append(files, file)
+		sum, _ := hex.DecodeString(p.ETag)
+		sums = append(sums, sum...)
+		size += p.Size
+		sizes = append(sizes, p.Size)
+	}
+
+	versionID, err := nextVersionID(bucket)
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	o := ObjectMD{ObjectKey: objectKey, VersionID: versionID, ObjectHeaders: u.ObjectHeaders}
+	target, err := s.prepareObjectPath(bucketName, &o)
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	if _, _, err = writeObjectFile(target, io.MultiReader(files...), nil); err != nil {
+		writeXMLError(w, "InternalError", "Error writing file: "+err.Error())
+		return
+	}
+
+	// S3 style multipart ETag: the MD5 of the part MD5s and the part count
+	sum := md5.Sum(sums)
+	etag := hex.EncodeToString(sum[:]) + "-" + strconv.Itoa(len(request.Parts))
+	now := time.Now().Format(timeFormat)
+	o.Size = strconv.FormatInt(size, 10)
+	o.ContentType = u.ContentType
+	o.LastModified = now
+	o.ETag = etag
+	o.PartSizes = sizes
+
+	tx := &Tx{}
+	tx.DeleteUpload(bucketName, uploadID)
+	if err = s.commitObject(tx, bucketName, o); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	os.RemoveAll(s.uploadDir(bucketName, uploadID))
+
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
+	writeXML(w, CompleteMultipartUploadResult{
+		Location: "/" + bucketName + "/" + objectKey,
+		Bucket:   bucketName,
+		Key:      objectKey,
+		ETag:     quoteETag(etag),
+	}, http.StatusOK)
+}
+
+// abortMultipartUpload answers DELETE /{bucket}/{key}?uploadId=ID.
+func (s *Server) abortMultipartUpload(w http.ResponseWriter, r *http.Request, bucketName, objectKey, uploadID string) {
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.lock(uploadLockKey(bucketName, uploadID))()
+
+	if u, ok := s.store.Upload(bucketName, uploadID); !ok || u.ObjectKey != objectKey {
+		writeXMLError(w, "NoSuchUpload", errNoSuchUpload.Error())
+		return
+	}
+	tx := &Tx{}
+	tx.DeleteUpload(bucketName, uploadID)
+	if err := s.store.Commit(tx); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	if err := os.RemoveAll(s.uploadDir(bucketName, uploadID)); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// listMultipartUploads answers GET /{bucket}?uploads.
+func (s *Server) listMultipartUploads(w http.ResponseWriter, r *http.Request, bucketName string) {
+	query := r.URL.Query()
+	maxUploads, err := queryInt(query.Get("max-uploads"), maxListUploads)
+	if err != nil {
+		writeXMLError(w, "InvalidArgument", "max-uploads must be a non-negative integer.")
+		return
+	}
+	maxUploads = min(maxUploads, maxListUploads)
+	prefix := query.Get("prefix")
+	keyMarker := query.Get("key-marker")
+	uploadIDMarker := query.Get("upload-id-marker")
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+
+	result := ListMultipartUploadsResult{
+		Bucket:         bucketName,
+		KeyMarker:      keyMarker,
+		UploadIdMarker: uploadIDMarker,
+		Prefix:         prefix,
+		MaxUploads:     maxUploads,
+	}
+	for _, u := range s.store.Uploads(bucketName) {
+		if !strings.HasPrefix(u.ObjectKey, prefix) {
+			continue
+		}
+		// uploads up to and including the markers were on earlier pages
+		if u.ObjectKey < keyMarker || (u.ObjectKey == keyMarker && (uploadIDMarker == "" || u.UploadID <= uploadIDMarker)) {
+			continue
+		}
+		if len(result.Uploads) == maxUploads {
+			result.IsTruncated = true
+			break
+		}
+		result.Uploads = append(result.Uploads, UploadInfo{
+			Key:          u.ObjectKey,
+			UploadId:     u.UploadID,
+			Initiated:    parseTime(u.Initiated).UTC().Format(isoTimeFormat),
+			StorageClass: "STANDARD",
+		})
+		result.NextKeyMarker = u.ObjectKey
+		result.NextUploadIdMarker = u.UploadID
+	}
+	// an empty page has no marker to carry on from, see listObjects
+	if len(result.Uploads) == 0 {
+		result.IsTruncated = false
+	}
+	writeXML(w, result, http.StatusOK)
+}
+
+// queryInt parses a non-negative integer query parameter, def when absent.
+func queryInt(value string, def int) (int, error) {
+	if value == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return n, nil
+}
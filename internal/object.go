@@ -1,16 +1,14 @@
 package internal
 
 import (
-	"encoding/csv"
+	"crypto/md5"
+	"encoding/base64"
 	"errors"
-	"io"
 	"net/http"
 	"os"
-	"path/filepath"
 	"strconv"
 	"strings"
 	"time"
-	"triple-s/config"
 )
 
 type ObjectMD struct {
@@ -18,214 +16,362 @@ type ObjectMD struct {
 	Size         string
 	ContentType  string
 	LastModified string
+	ETag         string
+	VersionID    string  `json:",omitempty"` // empty when written without versioning
+	DataName     string  `json:",omitempty"` // of the data file, see objectPath
+	DeleteMarker bool    `json:",omitempty"`
+	PartSizes    []int64 `json:",omitempty"` // of a multipart upload, in part order
+	ObjectHeaders
 }
 
-func UploadNewObject(w http.ResponseWriter, r *http.Request) {
+const (
+	timeFormat    = "2006/01/02 15:04:05"
+	isoTimeFormat = "2006-01-02T15:04:05.000Z"
+)
+
+// parseTime reads a timestamp stored in timeFormat, which is written in the
+// server's local time zone.
+func parseTime(value string) time.Time {
+	t, _ := time.ParseInLocation(timeFormat, value, time.Local)
+	return t
+}
+
+func (s *Server) UploadNewObject(w http.ResponseWriter, r *http.Request) {
 	// http errors handling
 	if r.Method != http.MethodPut {
-		writeXMLError(w, "MethodNotAllowed", "Error: only PUT command for /put/ url.", http.StatusMethodNotAllowed)
+		writeXMLError(w, "MethodNotAllowed", "Only PUT command for /put/ url.")
 		return
 	}
 
 	bucketName, objectKey, err := checkPathURL("/put/", r)
 	if err != nil {
-		writeXMLError(w, "BadRequest", "Error: "+err.Error(), http.StatusBadRequest)
+		writeKeyError(w, err)
 		return
 	}
 
 	if objectKey == "" {
-		writeXMLError(w, "BadRequest", "Error: object key cannot be empty.", http.StatusBadRequest)
+		writeXMLError(w, "InvalidArgument", "Object key cannot be empty.")
 		return
 	}
 
+	s.putObject(w, r, bucketName, objectKey)
+}
+
+func (s *Server) putObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	// hold the bucket shared and the object exclusively
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.lock(objectLockKey(bucketName, objectKey))()
+
 	// validate bucket existence
-	is, err := elementExists("/buckets.csv", bucketName)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
 		return
 	}
-	if !is {
-		writeXMLError(w, "BadRequest", "Error: bucket name does not exist.", http.StatusBadRequest)
+	if !checkBucketWritable(w, bucket) {
 		return
 	}
 
-	// Open file for writing
-	file, err := os.Create(filepath.Join(config.Directory+"/"+bucketName, objectKey))
+	// the digest the client computed, if it sent one
+	contentMD5, err := readContentMD5(r)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "InvalidDigest", err.Error())
 		return
 	}
-	defer file.Close()
 
-	// Write the file content from the request body to the file
-	_, err = io.Copy(file, r.Body)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error writing file: "+err.Error(), http.StatusInternalServerError)
+	// conditional writes: If-None-Match: * creates only, If-Match swaps
+	current, exists := s.store.Object(bucketName, objectKey)
+	if !checkPreconditions(w, r, current, exists) {
 		return
 	}
 
-	// Get the content length from the file after writing
-	fileInfo, err := file.Stat()
+	headers, err := readObjectHeaders(r)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "MetadataTooLarge", err.Error())
 		return
 	}
-	contentLength := strconv.FormatInt(fileInfo.Size(), 10)
-	contentType := r.Header.Get("Content-Type")
 
-	// Save object metadata in CSV
-	o := ObjectMD{
-		ObjectKey:    objectKey,
-		Size:         contentLength,
-		ContentType:  contentType,
-		LastModified: time.Now().Format("2006/01/02 15:04:05"),
+	// with versioning on every PUT gets a place of its own
+	versionID, err := nextVersionID(bucket)
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
 	}
-	err = writeObjectCSV(bucketName, o)
+	o := ObjectMD{ObjectKey: objectKey, VersionID: versionID, ObjectHeaders: headers}
+	target, err := s.prepareObjectPath(bucketName, &o)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
 
-	updateBucketCSV("Active", bucketName)
+	// Stage the request body and move it into place once complete
+	size, etag, err := writeObjectFile(target, r.Body, contentMD5)
+	if err != nil {
+		writeBodyError(w, err)
+		return
+	}
+
+	// Save object metadata together with the bucket status
+	now := time.Now().Format(timeFormat)
+	o.Size = strconv.FormatInt(size, 10)
+	o.ContentType = r.Header.Get("Content-Type")
+	o.LastModified = now
+	o.ETag = etag
+	if err = s.commitObject(&Tx{}, bucketName, o); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", quoteETag(etag))
+	if versionID != "" {
+		w.Header().Set("x-amz-version-id", versionID)
+	}
 	writeXMLResponse(w, "OK", "Successful creation of object!", http.StatusOK)
 }
 
-func RetrieveObject(w http.ResponseWriter, r *http.Request) {
+// commitObject records o, whose data is already in place, together with
+// the rest of tx. The data of a version o replaces for good is removed from
+// disk afterwards, and the data of o itself when the commit fails.
+func (s *Server) commitObject(tx *Tx, bucketName string, o ObjectMD) error {
+	unlockMeta := s.locks.lock(bucketMetaLockKey(bucketName))
+	bucket, _ := s.store.Bucket(bucketName)
+	bucket.LastModifiedTime = o.LastModified
+	replaced, hasReplaced := s.addObjectVersion(tx, bucketName, o)
+	tx.PutBucket(bucket)
+	err := s.store.Commit(tx)
+	unlockMeta()
+	if err != nil {
+		s.removeObjectData(bucketName, o)
+		return err
+	}
+	if hasReplaced {
+		s.removeObjectData(bucketName, replaced)
+	}
+	return nil
+}
+
+func (s *Server) RetrieveObject(w http.ResponseWriter, r *http.Request) {
 	// Check if the method is GET
 	if r.Method != http.MethodGet {
-		writeXMLError(w, "MethodNotAllowed", "Error: only GET command in /get/ url.", http.StatusMethodNotAllowed)
+		writeXMLError(w, "MethodNotAllowed", "Only GET command in /get/ url.")
 		return
 	}
 
 	// Parse bucket name and object key
 	bucketName, objectKey, err := checkPathURL("/get/", r)
 	if err != nil {
-		writeXMLError(w, "BadRequest", "Error: "+err.Error(), http.StatusBadRequest)
+		writeKeyError(w, err)
 		return
 	}
 
 	// Validate object key
 	if objectKey == "" {
-		writeXMLError(w, "BadRequest", "Error: object key cannot be empty.", http.StatusBadRequest)
+		writeXMLError(w, "InvalidArgument", "Object key cannot be empty.")
 		return
 	}
 
-	// Validate bucket existence
-	is, err := elementExists("/buckets.csv", bucketName)
+	s.getObject(w, r, bucketName, objectKey)
+}
+
+func (s *Server) getObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	partNumber, err := parsePartNumber(r)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
-	if !is {
-		writeXMLError(w, "BadRequest", "Error: bucket name does not exist.", http.StatusBadRequest)
+		writeXMLError(w, "InvalidArgument", err.Error())
 		return
 	}
 
-	// Validate object existence
-	is, err = elementExists("/"+bucketName+"/objects.csv", objectKey)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.rlock(objectLockKey(bucketName, objectKey))()
+
+	// Validate bucket existence
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "")
 		return
 	}
-	if !is {
-		writeXMLError(w, "BadRequest", "Error: object key does not exist.", http.StatusBadRequest)
+
+	// Validate object existence and get its metadata
+	o, err := s.findObject(w, r, bucketName, objectKey)
+	if err == errNoSuchKey {
+		writeXMLError(w, "NoSuchKey", "")
 		return
 	}
-
-	// Get the object metadata (e.g., Content-Type) from the CSV file
-	records, err := readCSV("/" + bucketName + "/objects.csv")
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeVersionError(w, err)
 		return
 	}
-
-	var contentType string
-	for _, v := range records {
-		if v[0] == objectKey {
-			contentType = v[2] // Assuming column 2 has Content-Type
-			break
-		}
+	contentType := o.ContentType
+	if !checkPreconditions(w, r, o, true) {
+		return
 	}
 
 	// Open and serve the object
-	filePath := filepath.Join(config.Directory, bucketName, objectKey)
-	file, err := os.Open(filePath)
+	file, err := os.Open(s.objectPath(bucketName, o))
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
 	defer file.Close()
 
-	// Set the correct Content-Type for the object
+	// Set the correct Content-Type and ETag for the object
 	w.Header().Set("Content-Type", contentType)
+	if o.ETag != "" {
+		w.Header().Set("ETag", quoteETag(o.ETag))
+	}
+	if o.VersionID != "" {
+		w.Header().Set("x-amz-version-id", o.VersionID)
+	}
+	setObjectMetadata(w, o.ObjectHeaders)
+	w.Header().Set("Accept-Ranges", "bytes")
+	if partNumber > 0 {
+		w.Header().Set("Last-Modified", parseTime(o.LastModified).UTC().Format(http.TimeFormat))
+		servePart(w, r, o, partNumber, file)
+		return
+	}
 
-	// Serve the file content
-	http.ServeContent(w, r, objectKey, time.Now(), file)
+	// Serve the file content with its stored modification time, ServeContent
+	// answers Range and If-Range from it and the ETag
+	http.ServeContent(w, r, objectKey, parseTime(o.LastModified), file)
 }
 
-func DeleteAnObject(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodDelete {
-		writeXMLError(w, "MethodNotAllowed", "Error: only DELETE command in /delete/ url.", http.StatusMethodNotAllowed)
-		return
+// readContentMD5 decodes the Content-MD5 header, nil when there is none.
+func readContentMD5(r *http.Request) ([]byte, error) {
+	value := r.Header.Get("Content-MD5")
+	if value == "" {
+		return nil, nil
 	}
-	bucketName, objectKey, err := checkPathURL("/delete/", r)
+	sum, err := base64.StdEncoding.DecodeString(value)
+	if err != nil || len(sum) != md5.Size {
+		return nil, errors.New("The Content-MD5 you specified is not valid.")
+	}
+	return sum, nil
+}
+
+// writeBodyError reports why a request body could not be stored.
+func writeBodyError(w http.ResponseWriter, err error) {
+	switch err {
+	case errBadDigest:
+		writeXMLError(w, "BadDigest", err.Error())
+	case errContentSHA256Mismatch:
+		writeXMLError(w, "XAmzContentSHA256Mismatch", err.Error())
+	case errChunkSignature:
+		writeXMLError(w, "SignatureDoesNotMatch", err.Error())
+	case errMalformedChunk:
+		writeXMLError(w, "IncompleteBody", err.Error())
+	default:
+		writeXMLError(w, "InternalError", "Error writing file: "+err.Error())
+	}
+}
+
+// headObject returns the metadata of an object without its body.
+func (s *Server) headObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	partNumber, err := parsePartNumber(r)
 	if err != nil {
-		writeXMLError(w, "BadRequest", "Error: "+err.Error(), http.StatusBadRequest)
+		writeXMLError(w, "InvalidArgument", err.Error())
 		return
 	}
-	// validate for len of object
-	if objectKey == "" {
-		writeXMLError(w, "BadRequest", "Error: object key cannot be empty.", http.StatusBadRequest)
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.rlock(objectLockKey(bucketName, objectKey))()
+
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "")
 		return
 	}
-	// validate bucket existence
-	is, err := elementExists("/buckets.csv", bucketName)
+	o, err := s.findObject(w, r, bucketName, objectKey)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeVersionError(w, err)
 		return
 	}
-	if !is {
-		writeXMLError(w, "BadRequest", "Error: bucket name does not exists$.", http.StatusBadRequest)
+	if !checkPreconditions(w, r, o, true) {
 		return
 	}
-
-	// validate object existence
-	pathToCSV := "/" + bucketName + "/objects.csv"
-	is, err = elementExists(pathToCSV, objectKey)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+	setObjectHeaders(w, o)
+	if partNumber > 0 {
+		if _, length, ok := setPartHeaders(w, r, o, partNumber); ok && length > 0 {
+			w.WriteHeader(http.StatusPartialContent)
+		}
 		return
 	}
-	if !is {
-		writeXMLError(w, "BadRequest", "Error: object key does not exists$.", http.StatusBadRequest)
+	w.Header().Set("Content-Length", o.Size)
+	w.WriteHeader(http.StatusOK)
+}
+
+// setObjectHeaders describes an object in the response headers.
+func setObjectHeaders(w http.ResponseWriter, o ObjectMD) {
+	if o.ContentType != "" {
+		w.Header().Set("Content-Type", o.ContentType)
+	}
+	w.Header().Set("Last-Modified", parseTime(o.LastModified).UTC().Format(http.TimeFormat))
+	w.Header().Set("Accept-Ranges", "bytes")
+	if o.ETag != "" {
+		w.Header().Set("ETag", quoteETag(o.ETag))
+	}
+	if o.VersionID != "" {
+		w.Header().Set("x-amz-version-id", o.VersionID)
+	}
+	setObjectMetadata(w, o.ObjectHeaders)
+}
+
+// quoteETag formats a stored hex digest the way S3 sends ETags.
+func quoteETag(etag string) string {
+	return `"` + etag + `"`
+}
+
+func (s *Server) DeleteAnObject(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		writeXMLError(w, "MethodNotAllowed", "Only DELETE command in /delete/ url.")
 		return
 	}
-	err = os.Remove(config.Directory + "/" + bucketName + "/" + objectKey)
+	bucketName, objectKey, err := checkPathURL("/delete/", r)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeKeyError(w, err)
 		return
 	}
-	err = deleteRecord(pathToCSV, objectKey)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+	// validate for len of object
+	if objectKey == "" {
+		writeXMLError(w, "InvalidArgument", "Object key cannot be empty.")
 		return
 	}
+	s.deleteObject(w, r, bucketName, objectKey)
+}
 
-	file, err := os.OpenFile(config.Directory+pathToCSV, os.O_RDONLY, 0o644)
-	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+func (s *Server) deleteObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	// hold the bucket shared and the object exclusively
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	defer s.locks.lock(objectLockKey(bucketName, objectKey))()
+
+	// validate bucket existence
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	if !checkBucketWritable(w, bucket) {
+		return
+	}
+	if bucket.Versioning != "" || r.URL.Query().Has("versionId") {
+		s.deleteVersioned(w, r, bucketName, objectKey)
 		return
 	}
-	defer file.Close()
 
-	reader := csv.NewReader(file)
-	records, err := reader.ReadAll()
+	// validate object existence
+	// deleting a key that is not there succeeds, as in S3
+	o, ok := s.store.Object(bucketName, objectKey)
+	if !ok {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	tx := &Tx{}
+	tx.DeleteObject(bucketName, objectKey)
+	err := s.store.Commit(tx)
 	if err != nil {
-		writeXMLError(w, "InternalServerError", "Error: "+err.Error(), http.StatusInternalServerError)
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
-	if len(records) == 1 {
-		updateBucketCSV("MarkedForDeletion", bucketName)
+	err = s.removeObjectData(bucketName, o)
+	if err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
 	}
 	w.WriteHeader(http.StatusNoContent)
 }
@@ -236,5 +382,8 @@ func checkPathURL(what string, r *http.Request) (string, string, error) {
 	if len(parts) != 2 {
 		return "", "", errors.New("Invalid URL format.")
 	}
+	if err := validateObjectKey(parts[1]); err != nil {
+		return "", "", err
+	}
 	return parts[0], parts[1], nil
 }
@@ -0,0 +1,65 @@
+package internal
+
+import (
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PresignURL returns a URL that lets anyone run method (GET or PUT) on
+// bucketName/objectKey until expires has passed, signed in the query string
+// with the secret of accessKey. accessKey may be empty when only one
+// credential exists.
+func (c Credentials) PresignURL(method, endpoint, bucketName, objectKey, accessKey, region string, expires time.Duration) (string, error) {
+	if method != http.MethodGet && method != http.MethodPut {
+		return "", errors.New("Error: only GET and PUT URLs can be presigned.")
+	}
+	if expires <= 0 || expires > maxPresignExpiry {
+		return "", errors.New("Error: expiry must be between 1 second and 7 days.")
+	}
+	if err := validateBucketName(bucketName); err != nil {
+		return "", errors.New("Error: " + err.Error())
+	}
+	if objectKey == "" {
+		return "", errors.New("Error: object key cannot be empty.")
+	}
+	if err := validateObjectKey(objectKey); err != nil {
+		return "", errors.New("Error: " + err.Error())
+	}
+	if accessKey == "" && len(c.secrets) == 1 {
+		for key := range c.secrets {
+			accessKey = key
+		}
+	}
+	if _, ok := c.secrets[accessKey]; !ok {
+		return "", errors.New("Error: unknown access key, pick one from credentials.csv with -access-key.")
+	}
+
+	now := time.Now().UTC()
+	sig := &signature{
+		accessKey:     accessKey,
+		date:          now,
+		scope:         strings.Join([]string{now.Format("20060102"), region, "s3", "aws4_request"}, "/"),
+		signedHeaders: []string{"host"},
+		payloadHash:   unsignedPayload,
+	}
+	query := url.Values{}
+	query.Set("X-Amz-Algorithm", signV4Algorithm)
+	query.Set("X-Amz-Credential", accessKey+"/"+sig.scope)
+	query.Set("X-Amz-Date", now.Format(amzDateFormat))
+	query.Set("X-Amz-Expires", strconv.Itoa(int(expires/time.Second)))
+	query.Set("X-Amz-SignedHeaders", "host")
+
+	rawURL := strings.TrimSuffix(endpoint, "/") + "/" + awsURIEncode(bucketName, true) + "/" + awsURIEncode(objectKey, false) + "?" + query.Encode()
+	r, err := http.NewRequest(method, rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	key := signingKey(c.secrets[accessKey], sig.scope)
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign(sig, canonicalRequest(r, sig))))
+	return rawURL + "&X-Amz-Signature=" + signature, nil
+}
@@ -0,0 +1,74 @@
+package internal
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+var errInvalidPartNumber = errors.New("Part number must be an integer between 1 and 10000, inclusive.")
+
+// parsePartNumber reads ?partNumber= of a GET or HEAD, 0 when there is none.
+func parsePartNumber(r *http.Request) (int, error) {
+	if !r.URL.Query().Has("partNumber") {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(r.URL.Query().Get("partNumber"))
+	if err != nil || n < 1 || n > maxPartNumber {
+		return 0, errInvalidPartNumber
+	}
+	return n, nil
+}
+
+// partRange finds the bytes of one part of o. An object that was not
+// uploaded in parts is a single part.
+func partRange(o ObjectMD, partNumber int) (start, length int64, ok bool) {
+	sizes := o.PartSizes
+	if len(sizes) == 0 {
+		size, _ := strconv.ParseInt(o.Size, 10, 64)
+		sizes = []int64{size}
+	}
+	if partNumber < 1 || partNumber > len(sizes) {
+		return 0, 0, false
+	}
+	for _, size := range sizes[:partNumber-1] {
+		start += size
+	}
+	return start, sizes[partNumber-1], true
+}
+
+// setPartHeaders describes part partNumber of o in the response headers. When
+// the part cannot be served the error is written and ok is false.
+func setPartHeaders(w http.ResponseWriter, r *http.Request, o ObjectMD, partNumber int) (start, length int64, ok bool) {
+	if r.Header.Get("Range") != "" {
+		writeXMLError(w, "InvalidRequest", "Cannot specify both Range header and partNumber query parameter.")
+		return 0, 0, false
+	}
+	start, length, ok = partRange(o, partNumber)
+	if !ok {
+		writeXMLError(w, "InvalidPartNumber", "")
+		return 0, 0, false
+	}
+	if len(o.PartSizes) > 0 {
+		w.Header().Set("x-amz-mp-parts-count", strconv.Itoa(len(o.PartSizes)))
+	}
+	w.Header().Set("Content-Length", strconv.FormatInt(length, 10))
+	if length > 0 {
+		w.Header().Set("Content-Range", "bytes "+strconv.FormatInt(start, 10)+"-"+strconv.FormatInt(start+length-1, 10)+"/"+o.Size)
+	}
+	return start, length, true
+}
+
+// servePart answers GET ?partNumber=N with the bytes of that part.
+func servePart(w http.ResponseWriter, r *http.Request, o ObjectMD, partNumber int, file *os.File) {
+	start, length, ok := setPartHeaders(w, r, o, partNumber)
+	if !ok {
+		return
+	}
+	if length > 0 {
+		w.WriteHeader(http.StatusPartialContent)
+	}
+	io.Copy(w, io.NewSectionReader(file, start, length))
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"net/http"
+	"strings"
+)
+
+// NewRouter serves the S3 path-style API:
+//
+//	GET    /                      list buckets
+//	PUT    /{bucket}              create bucket
+//	GET    /{bucket}              list objects (ListObjectsV2)
+//	HEAD   /{bucket}              check that a bucket exists
+//	DELETE /{bucket}              delete bucket
+//	DELETE /{bucket}?force        delete bucket and contents (admin)
+//	GET    /{bucket}?force        progress of a force delete (admin)
+//	GET|HEAD|PUT|DELETE /{bucket}/{key...}
+//
+// and multipart uploads:
+//
+//	POST   /{bucket}/{key...}?uploads                      create
+//	PUT    /{bucket}/{key...}?partNumber=N&uploadId=ID     upload part
+//	GET    /{bucket}/{key...}?uploadId=ID                  list parts
+//	POST   /{bucket}/{key...}?uploadId=ID                  complete
+//	DELETE /{bucket}/{key...}?uploadId=ID                  abort
+//	GET    /{bucket}?uploads                               list uploads
+//
+// The old /put/, /get/ and /delete/ prefixes keep working in compatibility
+// mode, which is why those names cannot be used as buckets.
+//
+// Every request goes through SigV4 authentication once credentials exist,
+// except GET /metrics, which serves Prometheus metrics about the others.
+func NewRouter(s *Server) http.Handler {
+	api := s.instrument(s.authenticate(http.HandlerFunc(s.route)))
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == metricsPath {
+			setRequestIDHeaders(w, newRequestID())
+			s.serveMetrics(w, r)
+			return
+		}
+		api.ServeHTTP(w, r)
+	})
+}
+
+// route dispatches by hand instead of through http.ServeMux, which would
+// redirect object keys containing "//" or "..".
+func (s *Server) route(w http.ResponseWriter, r *http.Request) {
+	// the legacy routes name a bucket alone or a bucket and a key, which may
+	// contain "/" itself
+	_, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	_, objectKey, _ = strings.Cut(objectKey, "/")
+	switch {
+	case strings.HasPrefix(r.URL.Path, "/put/"):
+		if objectKey == "" {
+			s.PutHandler(w, r)
+		} else {
+			s.UploadNewObject(w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, "/get/"):
+		if objectKey == "" {
+			s.GetHandler(w, r)
+		} else {
+			s.RetrieveObject(w, r)
+		}
+	case strings.HasPrefix(r.URL.Path, "/delete/"):
+		if objectKey == "" {
+			s.DeleteHandler(w, r)
+		} else {
+			s.DeleteAnObject(w, r)
+		}
+	default:
+		s.s3Handler(w, r)
+	}
+}
+
+func (s *Server) s3Handler(w http.ResponseWriter, r *http.Request) {
+	bucketName, objectKey, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	query := r.URL.Query()
+
+	if bucketName == "" {
+		switch r.Method {
+		case http.MethodGet:
+			s.listBuckets(w, r)
+		default:
+			writeXMLError(w, "MethodNotAllowed", "Only GET is allowed on /.")
+		}
+		return
+	}
+
+	if objectKey == "" {
+		switch {
+		case r.Method == http.MethodPut && query.Has("versioning"):
+			s.putBucketVersioning(w, r, bucketName)
+		case r.Method == http.MethodPut:
+			s.createBucket(w, r, bucketName)
+		case r.Method == http.MethodDelete:
+			s.deleteBucket(w, r, bucketName)
+		case r.Method == http.MethodGet && query.Has("force"):
+			s.getBucketDeletion(w, r, bucketName)
+		case r.Method == http.MethodGet && query.Has("versioning"):
+			s.getBucketVersioning(w, r, bucketName)
+		case r.Method == http.MethodGet && query.Has("versions"):
+			s.listObjectVersions(w, r, bucketName)
+		case r.Method == http.MethodGet && query.Has("uploads"):
+			s.listMultipartUploads(w, r, bucketName)
+		case r.Method == http.MethodGet:
+			s.listObjects(w, r, bucketName)
+		case r.Method == http.MethodHead:
+			s.headBucket(w, r, bucketName)
+		case r.Method == http.MethodPost && query.Has("delete"):
+			s.deleteObjects(w, r, bucketName)
+		default:
+			writeXMLError(w, "MethodNotAllowed", "Method is not allowed on a bucket.")
+		}
+		return
+	}
+
+	if err := validateObjectKey(objectKey); err != nil {
+		writeKeyError(w, err)
+		return
+	}
+
+	uploadID := query.Get("uploadId")
+	switch {
+	case r.Method == http.MethodPut && query.Has("uploadId") && r.Header.Get("X-Amz-Copy-Source") != "":
+		writeXMLError(w, "NotImplemented", "Copying into an upload part is not supported.")
+	case r.Method == http.MethodPut && query.Has("uploadId"):
+		s.uploadPart(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodPut && r.Header.Get("X-Amz-Copy-Source") != "":
+		s.copyObject(w, r, bucketName, objectKey)
+	case r.Method == http.MethodPut:
+		s.putObject(w, r, bucketName, objectKey)
+	case r.Method == http.MethodGet && query.Has("uploadId"):
+		s.listParts(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodGet:
+		s.getObject(w, r, bucketName, objectKey)
+	case r.Method == http.MethodHead:
+		s.headObject(w, r, bucketName, objectKey)
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		s.createMultipartUpload(w, r, bucketName, objectKey)
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		s.completeMultipartUpload(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		s.abortMultipartUpload(w, r, bucketName, objectKey, uploadID)
+	case r.Method == http.MethodDelete:
+		s.deleteObject(w, r, bucketName, objectKey)
+	default:
+		writeXMLError(w, "MethodNotAllowed", "Method is not allowed on an object.")
+	}
+}
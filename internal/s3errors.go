@@ -0,0 +1,75 @@
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"os"
+)
+
+// s3Error is how S3 answers with an error code: the HTTP status and the
+// message it gives when there is nothing more specific to say.
+type s3Error struct {
+	status  int
+	message string
+}
+
+// s3Errors is every error code the server sends. writeXMLError takes the
+// status from here so a code is always answered the same way.
+var s3Errors = map[string]s3Error{
+	"AccessDenied":                            {http.StatusForbidden, "Access Denied."},
+	"AuthorizationHeaderMalformed":            {http.StatusBadRequest, "The authorization header is malformed."},
+	"AuthorizationQueryParametersError":       {http.StatusBadRequest, "The query string authorization parameters are malformed."},
+	"BadDigest":                               {http.StatusBadRequest, "The Content-MD5 you specified did not match what we received."},
+	"BucketAlreadyOwnedByYou":                 {http.StatusConflict, "Your previous request to create the named bucket succeeded and you already own it."},
+	"BucketNotEmpty":                          {http.StatusConflict, "The bucket you tried to delete is not empty."},
+	"EntityTooSmall":                          {http.StatusBadRequest, "Your proposed upload is smaller than the minimum allowed object size."},
+	"IllegalVersioningConfigurationException": {http.StatusBadRequest, "The versioning configuration specified in the request is invalid."},
+	"IncompleteBody":                          {http.StatusBadRequest, "You did not provide the number of bytes specified by the Content-Length HTTP header."},
+	"InternalError":                           {http.StatusInternalServerError, "We encountered an internal error. Please try again."},
+	"InvalidAccessKeyId":                      {http.StatusForbidden, "The AWS Access Key Id you provided does not exist in our records."},
+	"InvalidArgument":                         {http.StatusBadRequest, "Invalid Argument."},
+	"InvalidBucketName":                       {http.StatusBadRequest, "The specified bucket is not valid."},
+	"InvalidDigest":                           {http.StatusBadRequest, "The Content-MD5 you specified is not valid."},
+	"InvalidPart":                             {http.StatusBadRequest, "One or more of the specified parts could not be found."},
+	"InvalidPartNumber":                       {http.StatusRequestedRangeNotSatisfiable, "The requested partnumber is not satisfiable."},
+	"InvalidPartOrder":                        {http.StatusBadRequest, "The list of parts was not in ascending order."},
+	"InvalidRequest":                          {http.StatusBadRequest, "Invalid Request."},
+	"KeyTooLongError":                         {http.StatusBadRequest, "Your key is too long."},
+	"MalformedXML":                            {http.StatusBadRequest, "The XML you provided was not well-formed or did not validate against our published schema."},
+	"MetadataTooLarge":                        {http.StatusBadRequest, "Your metadata headers exceed the maximum allowed metadata size."},
+	"MethodNotAllowed":                        {http.StatusMethodNotAllowed, "The specified method is not allowed against this resource."},
+	"NoSuchBucket":                            {http.StatusNotFound, "The specified bucket does not exist."},
+	"NoSuchKey":                               {http.StatusNotFound, "The specified key does not exist."},
+	"NoSuchUpload":                            {http.StatusNotFound, "The specified multipart upload does not exist."},
+	"NoSuchVersion":                           {http.StatusNotFound, "The specified version does not exist."},
+	"NotImplemented":                          {http.StatusNotImplemented, "A header you provided implies functionality that is not implemented."},
+	"OperationAborted":                        {http.StatusConflict, "A conflicting conditional operation is currently in progress against this resource. Try again."},
+	"PreconditionFailed":                      {http.StatusPreconditionFailed, "At least one of the preconditions you specified did not hold."},
+	"RequestTimeTooSkewed":                    {http.StatusForbidden, "The difference between the request time and the current time is too large."},
+	"SignatureDoesNotMatch":                   {http.StatusForbidden, "The request signature we calculated does not match the signature you provided."},
+	"XAmzContentSHA256Mismatch":               {http.StatusBadRequest, "The provided 'x-amz-content-sha256' header does not match what was computed."},
+}
+
+// lookupS3Error finds code in s3Errors. A code missing from the catalog is a
+// bug and is answered as an internal error.
+func lookupS3Error(code string) (string, s3Error) {
+	if e, ok := s3Errors[code]; ok {
+		return code, e
+	}
+	return "InternalError", s3Errors["InternalError"]
+}
+
+// hostID stands for this server in x-amz-id-2 and in error responses, the
+// way S3 names the host that answered.
+var hostID = func() string {
+	name, _ := os.Hostname()
+	sum := sha256.Sum256([]byte("triple-s/" + name))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}()
+
+// setRequestIDHeaders tags a response with the ID of its request.
+func setRequestIDHeaders(w http.ResponseWriter, requestID string) {
+	w.Header().Set("x-amz-request-id", requestID)
+	w.Header().Set("x-amz-id-2", hostID)
+}
@@ -0,0 +1,50 @@
+package internal
+
+import "sync/atomic"
+
+// Server holds what the handlers share: the data directory the buckets live
+// in, their metadata, the credentials requests are signed with, the
+// background work started on their behalf and the metrics of what it served.
+type Server struct {
+	directory   string
+	store       MetaStore
+	credentials Credentials
+	locks       *lockManager
+	deletions   *bucketDeletions
+	metrics     *requestMetrics
+
+	// accessLog holds nil when access logging is off. Requests that outlive
+	// the shutdown timeout can still be logging while it is closed, hence
+	// the atomic.
+	accessLog atomic.Pointer[accessLogger]
+}
+
+// NewServer opens the metadata store under directory, loads its credentials
+// and cleans up what an earlier run left behind.
+func NewServer(directory string) (*Server, error) {
+	store, err := openStore(directory)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		directory: directory,
+		store:     store,
+		locks:     newLockManager(),
+		deletions: newBucketDeletions(),
+		metrics:   newRequestMetrics(),
+	}
+	if s.credentials, err = LoadCredentials(directory); err == nil {
+		err = s.cleanStaging()
+	}
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close flushes and closes the metadata journal. Requests still running
+// afterwards can read, their commits fail with errStoreClosed.
+func (s *Server) Close() error {
+	return s.store.Close()
+}
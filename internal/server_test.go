@@ -0,0 +1,115 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// TestConcurrentWrites runs bucket and object writes and deletes against each
+// other and checks that the store and the files on disk still agree. It is
+// meant to be run with -race.
+func TestConcurrentWrites(t *testing.T) {
+	s, err := NewServer(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.Close() })
+	server := httptest.NewServer(NewRouter(s))
+	defer server.Close()
+
+	do := func(method, path, body string) {
+		r, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp, err := server.Client().Do(r)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			t.Errorf("%s %s: %s", method, path, resp.Status)
+		}
+	}
+
+	buckets := []string{"bucket-a", "bucket-b", "bucket-c"}
+	var wg sync.WaitGroup
+	for worker := 0; worker < 8; worker++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				bucket := buckets[(worker+i)%len(buckets)]
+				key := "dir/key-" + strconv.Itoa(i%5)
+				switch (worker + i) % 4 {
+				case 0:
+					do(http.MethodPut, "/put/"+bucket, "")
+				case 1:
+					do(http.MethodPut, "/put/"+bucket+"/"+key, fmt.Sprintf("worker %d write %d", worker, i))
+				case 2:
+					do(http.MethodDelete, "/delete/"+bucket+"/"+key, "")
+				case 3:
+					do(http.MethodDelete, "/delete/"+bucket, "")
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	onDisk := make(map[string]bool)
+	entries, err := os.ReadDir(s.directory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range entries {
+		if entry.Name() != metaDirName {
+			onDisk[entry.Name()] = true
+		}
+	}
+	for _, bucket := range s.store.Buckets() {
+		if !onDisk[bucket.Name] {
+			t.Errorf("bucket %s has no directory", bucket.Name)
+		}
+		delete(onDisk, bucket.Name)
+
+		s.store.WalkVersions(bucket.Name, "", func(key string, versions []ObjectMD) bool {
+			for _, v := range versions {
+				if v.DeleteMarker {
+					continue
+				}
+				info, err := os.Stat(s.objectPath(bucket.Name, v))
+				if err != nil {
+					t.Errorf("%s/%s: %v", bucket.Name, key, err)
+				} else if strconv.FormatInt(info.Size(), 10) != v.Size {
+					t.Errorf("%s/%s: %d bytes on disk, %s in the store", bucket.Name, key, info.Size(), v.Size)
+				}
+			}
+			return true
+		})
+
+		referenced := s.referencedDataFiles(bucket.Name)
+		filepath.WalkDir(filepath.Join(s.directory, bucket.Name), func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				t.Error(err)
+				return nil
+			}
+			if !d.IsDir() && !referenced[path] {
+				t.Errorf("%s is not in the store", path)
+			}
+			return nil
+		})
+	}
+	for name := range onDisk {
+		t.Errorf("directory %s has no bucket", name)
+	}
+}
@@ -0,0 +1,221 @@
+package internal
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// stagingPrefix marks uploads that are still being received.
+const stagingPrefix = ".staging-"
+
+var errBadDigest = errors.New("The Content-MD5 you specified did not match what we received.")
+
+// keyFileName is the name an object key is stored under on disk. Keys are
+// hashed, so a key of any length or with any number of "/" in it becomes one
+// flat file name that cannot clash with the server's own files.
+func keyFileName(objectKey string) string {
+	sum := sha256.Sum256([]byte(objectKey))
+	return hex.EncodeToString(sum[:])
+}
+
+// withDataName is the data file of one write under path, which is where an
+// object or part written before data names existed is kept.
+func withDataName(path, dataName string) string {
+	if dataName == "" {
+		return path
+	}
+	return path + "." + dataName
+}
+
+// isDataFileName reports whether name carries a data name, as every data
+// file written by withDataName does.
+func isDataFileName(name string) bool {
+	i := strings.LastIndexByte(name, '.')
+	if i <= 0 || len(name)-i-1 != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(name[i+1:])
+	return err == nil
+}
+
+// migrateObjectFiles moves object data written before keys were hashed from
+// the key itself to keyFileName. It is safe to run again after a crash.
+func migrateObjectFiles(directory string, store MetaStore) error {
+	for _, bucket := range store.Buckets() {
+		bucketDir := filepath.Join(directory, bucket.Name)
+		moved := false
+		var err error
+		store.WalkVersions(bucket.Name, "", func(key string, versions []ObjectMD) bool {
+			// the old layout could only hold keys that were plain file names
+			if strings.ContainsRune(key, '/') || key == "." || key == ".." {
+				return true
+			}
+			for _, v := range versions {
+				if v.VersionID != "" || v.DataName != "" || v.DeleteMarker {
+					continue
+				}
+				target := filepath.Join(bucketDir, keyFileName(key))
+				if _, statErr := os.Stat(target); statErr == nil {
+					continue
+				}
+				err = os.Rename(filepath.Join(bucketDir, key), target)
+				if os.IsNotExist(err) {
+					err = nil
+					continue
+				}
+				if err != nil {
+					return false
+				}
+				moved = true
+			}
+			return true
+		})
+		if err != nil {
+			return err
+		}
+		if moved {
+			syncDir(bucketDir)
+		}
+	}
+	return nil
+}
+
+// writeObjectFile streams body into a staging file next to target and only
+// renames it to target once the whole body is on disk. Each write has a
+// target of its own, so the data of the previous version stays in place
+// until the new one is committed.
+//
+// The MD5 of the body is returned as the object's ETag. When contentMD5 is
+// given and does not match, target is left untouched and errBadDigest is
+// returned.
+func writeObjectFile(target string, body io.Reader, contentMD5 []byte) (int64, string, error) {
+	dir := filepath.Dir(target)
+	tmp, err := os.CreateTemp(dir, stagingPrefix+"*")
+	if err != nil {
+		return 0, "", err
+	}
+	tmpName := tmp.Name()
+
+	hash := md5.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), body)
+	sum := hash.Sum(nil)
+	if err == nil && contentMD5 != nil && !bytes.Equal(sum, contentMD5) {
+		err = errBadDigest
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpName, target)
+	}
+	if err != nil {
+		os.Remove(tmpName)
+		return 0, "", err
+	}
+	syncDir(dir)
+	return n, hex.EncodeToString(sum), nil
+}
+
+// cleanStaging removes what a crash or a restart left behind: staging files
+// of uploads that were cut off, data files written but never committed or
+// replaced but not yet removed, and the part directories of multipart
+// uploads the metadata store does not know about.
+func (s *Server) cleanStaging() error {
+	buckets, err := os.ReadDir(s.directory)
+	if err != nil {
+		return err
+	}
+	for _, bucket := range buckets {
+		if !bucket.IsDir() || bucket.Name() == metaDirName {
+			continue
+		}
+		bucketDir := filepath.Join(s.directory, bucket.Name())
+		referenced := s.referencedDataFiles(bucket.Name())
+		if err = removeLeftovers(bucketDir, referenced); err != nil {
+			return err
+		}
+
+		versions, err := os.ReadDir(filepath.Join(bucketDir, versionsDirName))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, version := range versions {
+			if err = removeLeftovers(filepath.Join(bucketDir, versionsDirName, version.Name()), referenced); err != nil {
+				return err
+			}
+		}
+
+		uploads, err := os.ReadDir(filepath.Join(bucketDir, multipartDirName))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		for _, upload := range uploads {
+			uploadPath := filepath.Join(bucketDir, multipartDirName, upload.Name())
+			if _, ok := s.store.Upload(bucket.Name(), upload.Name()); !ok {
+				err = os.RemoveAll(uploadPath)
+			} else {
+				err = removeLeftovers(uploadPath, referenced)
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// referencedDataFiles collects the paths of every object version and part
+// of bucketName the store knows, nil for a bucket it does not know.
+func (s *Server) referencedDataFiles(bucketName string) map[string]bool {
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		return nil
+	}
+	referenced := make(map[string]bool)
+	s.store.WalkVersions(bucketName, "", func(key string, versions []ObjectMD) bool {
+		for _, v := range versions {
+			referenced[s.objectPath(bucketName, v)] = true
+		}
+		return true
+	})
+	for _, u := range s.store.Uploads(bucketName) {
+		for _, p := range u.Parts {
+			referenced[s.partPath(bucketName, u.UploadID, p)] = true
+		}
+	}
+	return referenced
+}
+
+// removeLeftovers removes the staging files in dir and, unless referenced is
+// nil, the data files it does not list.
+func removeLeftovers(dir string, referenced map[string]bool) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(dir, entry.Name())
+		stale := strings.HasPrefix(entry.Name(), stagingPrefix)
+		if referenced != nil && entry.Type().IsRegular() && isDataFileName(entry.Name()) && !referenced[path] {
+			stale = true
+		}
+		if stale {
+			if err = os.Remove(path); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
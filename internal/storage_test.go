@@ -0,0 +1,35 @@
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// FuzzKeyFileName checks that the file name of any accepted key is a plain
+// name inside the bucket directory that none of the server's own files use,
+// and that the cleanup at startup does not take it for a leftover.
+func FuzzKeyFileName(f *testing.F) {
+	for _, seed := range []string{"", "key", "dir/key", ".", "..", "../key", "_meta", ".multipart", ".versions", stagingPrefix + "x"} {
+		f.Add(seed)
+	}
+	bucketDir := filepath.Join(f.TempDir(), "bucket")
+
+	f.Fuzz(func(t *testing.T, key string) {
+		if validateObjectKey(key) != nil {
+			return
+		}
+		name := keyFileName(key)
+		if filepath.Dir(filepath.Join(bucketDir, name)) != bucketDir {
+			t.Fatalf("key %q: %q does not stay directly in %s", key, name, bucketDir)
+		}
+		switch {
+		case name == "." || name == "..", name == metaDirName, name == multipartDirName, name == versionsDirName:
+			t.Fatalf("key %q: %q is a reserved name", key, name)
+		case strings.HasPrefix(name, stagingPrefix):
+			t.Fatalf("key %q: %q looks like a staging file", key, name)
+		case isDataFileName(name):
+			t.Fatalf("key %q: %q looks like a data file", key, name)
+		}
+	})
+}
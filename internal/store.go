@@ -0,0 +1,799 @@
+package internal
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// metaDirName holds the server's own files inside the data directory. The
+// underscore can never appear in a bucket name, so it cannot collide.
+const metaDirName = "_meta"
+
+const (
+	opPutBucket     = "putBucket"
+	opDeleteBucket  = "deleteBucket"
+	opPutObject     = "putObject"
+	opDeleteObject  = "deleteObject"
+	opPutUpload     = "putUpload"
+	opDeleteUpload  = "deleteUpload"
+	opPutPart       = "putPart"
+	opPutVersion    = "putVersion"
+	opDeleteVersion = "deleteVersion"
+)
+
+// compaction is attempted once the journal holds this many records.
+const compactThreshold = 1024
+
+var (
+	errNoSuchBucket = errors.New("bucket does not exist.")
+	errNoSuchUpload = errors.New("The specified multipart upload does not exist.")
+	errStoreClosed  = errors.New("metadata store is closed.")
+)
+
+// MetaStore keeps bucket and object metadata. Reads are served from memory,
+// writes are grouped into a Tx which is applied all-or-nothing.
+type MetaStore interface {
+	Bucket(name string) (Bucket, bool)
+	Buckets() []Bucket
+	Object(bucket, key string) (ObjectMD, bool)
+	Objects(bucket string) []ObjectMD
+	WalkObjects(bucket, from string, fn func(o ObjectMD) bool)
+	ObjectCount(bucket string) int
+	KeyCount(bucket string) int
+	Stats() StoreStats
+	Versions(bucket, key string) []ObjectMD
+	WalkVersions(bucket, from string, fn func(key string, versions []ObjectMD) bool)
+	Upload(bucket, uploadID string) (Upload, bool)
+	Uploads(bucket string) []Upload
+	Commit(tx *Tx) error
+	Close() error
+}
+
+// StoreStats sums up what the store holds.
+type StoreStats struct {
+	Buckets  int
+	Objects  int   // current objects, not counting older versions
+	Versions int   // every version kept, delete markers excluded
+	Bytes    int64 // size of every version kept
+}
+
+// Op is a single change inside a transaction.
+type Op struct {
+	Kind     string    `json:"kind"`
+	Bucket   string    `json:"bucket"`
+	Key      string    `json:"key,omitempty"`
+	BucketMD *Bucket   `json:"bucketMD,omitempty"`
+	Object   *ObjectMD `json:"object,omitempty"`
+	UploadID string    `json:"uploadId,omitempty"`
+	Upload   *Upload   `json:"upload,omitempty"`
+	Part     *Part     `json:"part,omitempty"`
+	Version  string    `json:"version,omitempty"`
+}
+
+// Tx collects changes that must reach the store together.
+type Tx struct {
+	Ops []Op
+}
+
+func (tx *Tx) PutBucket(b Bucket) {
+	tx.Ops = append(tx.Ops, Op{Kind: opPutBucket, Bucket: b.Name, BucketMD: &b})
+}
+
+func (tx *Tx) DeleteBucket(name string) {
+	tx.Ops = append(tx.Ops, Op{Kind: opDeleteBucket, Bucket: name})
+}
+
+func (tx *Tx) PutObject(bucket string, o ObjectMD) {
+	tx.Ops = append(tx.Ops, Op{Kind: opPutObject, Bucket: bucket, Key: o.ObjectKey, Object: &o})
+}
+
+func (tx *Tx) DeleteObject(bucket, key string) {
+	tx.Ops = append(tx.Ops, Op{Kind: opDeleteObject, Bucket: bucket, Key: key})
+}
+
+func (tx *Tx) PutUpload(bucket string, u Upload) {
+	tx.Ops = append(tx.Ops, Op{Kind: opPutUpload, Bucket: bucket, UploadID: u.UploadID, Upload: &u})
+}
+
+func (tx *Tx) DeleteUpload(bucket, uploadID string) {
+	tx.Ops = append(tx.Ops, Op{Kind: opDeleteUpload, Bucket: bucket, UploadID: uploadID})
+}
+
+func (tx *Tx) PutPart(bucket, uploadID string, p Part) {
+	tx.Ops = append(tx.Ops, Op{Kind: opPutPart, Bucket: bucket, UploadID: uploadID, Part: &p})
+}
+
+// PutVersion adds o as the newest version of its key. A "null" version
+// replaces the earlier null version of the key.
+func (tx *Tx) PutVersion(bucket string, o ObjectMD) {
+	tx.Ops = append(tx.Ops, Op{Kind: opPutVersion, Bucket: bucket, Key: o.ObjectKey, Object: &o})
+}
+
+// DeleteVersion removes one version of key for good.
+func (tx *Tx) DeleteVersion(bucket, key, versionID string) {
+	tx.Ops = append(tx.Ops, Op{Kind: opDeleteVersion, Bucket: bucket, Key: key, Version: versionID})
+}
+
+type journalRecord struct {
+	Ops []Op `json:"ops"`
+}
+
+// bucketIndex keeps the current object of every key in objects. Keys that
+// have been written while versioning was on also keep their whole history,
+// newest first, in versions; keys lists every key found in either.
+type bucketIndex struct {
+	md       Bucket
+	objects  map[string]ObjectMD
+	versions map[string][]ObjectMD
+	keys     []string // sorted
+	uploads  map[string]Upload
+	live     int // records its keys and uploads take in a compacted journal
+}
+
+func (idx *bucketIndex) addKey(key string) {
+	i := sort.SearchStrings(idx.keys, key)
+	if i < len(idx.keys) && idx.keys[i] == key {
+		return
+	}
+	idx.keys = append(idx.keys, "")
+	copy(idx.keys[i+1:], idx.keys[i:])
+	idx.keys[i] = key
+}
+
+// removeKey drops key once neither an object nor a history is left.
+func (idx *bucketIndex) removeKey(key string) {
+	if _, ok := idx.objects[key]; ok {
+		return
+	}
+	if _, ok := idx.versions[key]; ok {
+		return
+	}
+	i := sort.SearchStrings(idx.keys, key)
+	if i < len(idx.keys) && idx.keys[i] == key {
+		idx.keys = append(idx.keys[:i], idx.keys[i+1:]...)
+	}
+}
+
+// history returns the versions of key, newest first. An object written
+// before versioning was turned on is its only version.
+func (idx *bucketIndex) history(key string) []ObjectMD {
+	if versions, ok := idx.versions[key]; ok {
+		return versions
+	}
+	if o, ok := idx.objects[key]; ok {
+		return []ObjectMD{o}
+	}
+	return nil
+}
+
+// records is the number of records key takes in a compacted journal.
+func (idx *bucketIndex) records(key string) int {
+	if versions, ok := idx.versions[key]; ok {
+		return len(versions)
+	}
+	if _, ok := idx.objects[key]; ok {
+		return 1
+	}
+	return 0
+}
+
+// setHistory stores versions as the history of key and makes the newest one
+// current unless it is a delete marker.
+func (idx *bucketIndex) setHistory(key string, versions []ObjectMD) {
+	if len(versions) == 0 {
+		delete(idx.versions, key)
+		delete(idx.objects, key)
+		idx.removeKey(key)
+		return
+	}
+	idx.versions[key] = versions
+	if versions[0].DeleteMarker {
+		delete(idx.objects, key)
+	} else {
+		idx.objects[key] = versions[0]
+	}
+	idx.addKey(key)
+}
+
+// journalStore is a MetaStore backed by an append-only log of transactions,
+// one JSON record per line, replayed into an in-memory index on open.
+type journalStore struct {
+	mu      sync.RWMutex
+	path    string
+	file    *os.File
+	size    int64
+	records int
+	live    int // records a freshly compacted journal would hold
+	buckets map[string]*bucketIndex
+}
+
+// openStore opens the metadata journal under directory, imports the old CSV
+// layout on the first start and moves object files written under their raw
+// key to their hashed name.
+func openStore(directory string) (MetaStore, error) {
+	metaDir := filepath.Join(directory, metaDirName)
+	if err := os.MkdirAll(metaDir, 0o755); err != nil {
+		return nil, err
+	}
+	s, err := openJournalStore(filepath.Join(metaDir, "journal.log"))
+	if err != nil {
+		return nil, err
+	}
+	// the import is marked done only once it is committed, so an import
+	// that failed is tried again on the next start
+	if err = migrateCSV(directory, s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	if err = migrateObjectFiles(directory, s); err != nil {
+		s.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func openJournalStore(path string) (*journalStore, error) {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	s := &journalStore{
+		path:    path,
+		file:    file,
+		buckets: make(map[string]*bucketIndex),
+	}
+	if err = s.replay(); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// replay rebuilds the index from the journal. A torn record at the tail,
+// left by a crash in the middle of a write, is cut off. A damaged record
+// with others after it is not something a crash leaves behind, and cutting
+// it off would lose the transactions that follow, so it is an error.
+func (s *journalStore) replay() error {
+	reader := bufio.NewReader(s.file)
+	var good int64
+	for {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		var rec journalRecord
+		if err = json.Unmarshal(line, &rec); err != nil {
+			if _, peekErr := reader.Peek(1); peekErr != io.EOF {
+				return fmt.Errorf("Error: %s: damaged record at byte %d with records after it: %w", s.path, good, err)
+			}
+			break
+		}
+		for _, op := range rec.Ops {
+			s.apply(op)
+		}
+		s.records++
+		good += int64(len(line))
+	}
+	if err := s.file.Truncate(good); err != nil {
+		return err
+	}
+	s.size = good
+	return nil
+}
+
+// apply applies op to the index and keeps the live record counts in step.
+func (s *journalStore) apply(op Op) {
+	before := s.liveRecords(op)
+	s.applyOp(op)
+	delta := s.liveRecords(op) - before
+	s.live += delta
+	if op.Kind != opPutBucket && op.Kind != opDeleteBucket && delta != 0 {
+		s.buckets[op.Bucket].live += delta
+	}
+}
+
+// liveRecords is the number of live records of what op changes.
+func (s *journalStore) liveRecords(op Op) int {
+	idx, ok := s.buckets[op.Bucket]
+	if !ok {
+		return 0
+	}
+	switch op.Kind {
+	case opPutBucket, opDeleteBucket:
+		return 1 + idx.live
+	case opPutUpload, opDeleteUpload, opPutPart:
+		if _, ok = idx.uploads[op.UploadID]; ok {
+			return 1
+		}
+		return 0
+	}
+	return idx.records(op.Key)
+}
+
+func (s *journalStore) applyOp(op Op) {
+	switch op.Kind {
+	case opPutBucket:
+		if idx, ok := s.buckets[op.Bucket]; ok {
+			idx.md = *op.BucketMD
+			return
+		}
+		s.buckets[op.Bucket] = &bucketIndex{
+			md:       *op.BucketMD,
+			objects:  make(map[string]ObjectMD),
+			versions: make(map[string][]ObjectMD),
+			uploads:  make(map[string]Upload),
+		}
+	case opDeleteBucket:
+		delete(s.buckets, op.Bucket)
+	case opPutObject:
+		idx, ok := s.buckets[op.Bucket]
+		if !ok {
+			return
+		}
+		idx.objects[op.Key] = *op.Object
+		idx.addKey(op.Key)
+	case opDeleteObject:
+		idx, ok := s.buckets[op.Bucket]
+		if !ok {
+			return
+		}
+		if _, ok = idx.objects[op.Key]; !ok {
+			return
+		}
+		delete(idx.objects, op.Key)
+		idx.removeKey(op.Key)
+	case opPutVersion:
+		idx, ok := s.buckets[op.Bucket]
+		if !ok {
+			return
+		}
+		// copy on write like parts, the new version goes in front
+		old := idx.history(op.Key)
+		versions := make([]ObjectMD, 0, len(old)+1)
+		versions = append(versions, *op.Object)
+		for _, v := range old {
+			if sameVersion(v.VersionID, op.Object.VersionID) {
+				continue
+			}
+			versions = append(versions, v)
+		}
+		idx.setHistory(op.Key, versions)
+	case opDeleteVersion:
+		idx, ok := s.buckets[op.Bucket]
+		if !ok {
+			return
+		}
+		old := idx.history(op.Key)
+		versions := make([]ObjectMD, 0, len(old))
+		for _, v := range old {
+			if !sameVersion(v.VersionID, op.Version) {
+				versions = append(versions, v)
+			}
+		}
+		idx.setHistory(op.Key, versions)
+	case opPutUpload:
+		if idx, ok := s.buckets[op.Bucket]; ok {
+			idx.uploads[op.UploadID] = *op.Upload
+		}
+	case opDeleteUpload:
+		if idx, ok := s.buckets[op.Bucket]; ok {
+			delete(idx.uploads, op.UploadID)
+		}
+	case opPutPart:
+		idx, ok := s.buckets[op.Bucket]
+		if !ok {
+			return
+		}
+		u, ok := idx.uploads[op.UploadID]
+		if !ok {
+			return
+		}
+		// copy on write, readers may still hold the old slice
+		i := sort.Search(len(u.Parts), func(i int) bool { return u.Parts[i].PartNumber >= op.Part.PartNumber })
+		parts := make([]Part, 0, len(u.Parts)+1)
+		parts = append(parts, u.Parts[:i]...)
+		parts = append(parts, *op.Part)
+		if i < len(u.Parts) && u.Parts[i].PartNumber == op.Part.PartNumber {
+			i++
+		}
+		u.Parts = append(parts, u.Parts[i:]...)
+		idx.uploads[op.UploadID] = u
+	}
+}
+
+// check makes sure every op of a transaction can be applied, so that a
+// transaction is never half-visible in memory.
+func (s *journalStore) check(ops []Op) error {
+	exists := make(map[string]bool)
+	uploads := make(map[string]bool)
+	uploadExists := func(bucket, uploadID string) bool {
+		if is, ok := uploads[bucket+"/"+uploadID]; ok {
+			return is
+		}
+		idx, ok := s.buckets[bucket]
+		if !ok {
+			return false
+		}
+		_, ok = idx.uploads[uploadID]
+		return ok
+	}
+	bucketExists := func(name string) bool {
+		if is, ok := exists[name]; ok {
+			return is
+		}
+		_, ok := s.buckets[name]
+		return ok
+	}
+	for _, op := range ops {
+		switch op.Kind {
+		case opPutBucket:
+			exists[op.Bucket] = true
+		case opDeleteBucket:
+			if !bucketExists(op.Bucket) {
+				return errNoSuchBucket
+			}
+			exists[op.Bucket] = false
+		case opPutObject, opDeleteObject, opPutVersion, opDeleteVersion:
+			if !bucketExists(op.Bucket) {
+				return errNoSuchBucket
+			}
+		case opPutUpload:
+			if !bucketExists(op.Bucket) {
+				return errNoSuchBucket
+			}
+			uploads[op.Bucket+"/"+op.UploadID] = true
+		case opDeleteUpload, opPutPart:
+			if !bucketExists(op.Bucket) {
+				return errNoSuchBucket
+			}
+			if !uploadExists(op.Bucket, op.UploadID) {
+				return errNoSuchUpload
+			}
+			if op.Kind == opDeleteUpload {
+				uploads[op.Bucket+"/"+op.UploadID] = false
+			}
+		default:
+			return errors.New("unknown metadata operation " + op.Kind)
+		}
+	}
+	return nil
+}
+
+func (s *journalStore) Commit(tx *Tx) error {
+	if tx == nil || len(tx.Ops) == 0 {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return errStoreClosed
+	}
+	if err := s.check(tx.Ops); err != nil {
+		return err
+	}
+
+	line, err := json.Marshal(journalRecord{Ops: tx.Ops})
+	if err != nil {
+		return err
+	}
+	line = append(line, '\n')
+	if _, err = s.file.Write(line); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	if err = s.file.Sync(); err != nil {
+		s.file.Truncate(s.size)
+		return err
+	}
+	s.size += int64(len(line))
+	s.records++
+
+	for _, op := range tx.Ops {
+		s.apply(op)
+	}
+
+	// the transaction is already durable, a failed compaction only costs space
+	if s.records >= compactThreshold && s.records > 2*s.live {
+		if err = s.compact(); err != nil {
+			log.Printf("metadata compaction failed: %v\n", err)
+		}
+	}
+	return nil
+}
+
+// compact rewrites the journal as one record per live entry. The new log is
+// written aside and renamed over the old one, so a crash leaves either. The
+// file it is written through is the one appended to afterwards, so there is
+// nothing left to reopen, and fail, once the rename is done.
+func (s *journalStore) compact() error {
+	tmpPath := s.path + ".compact"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	writer := bufio.NewWriter(tmp)
+	var size int64
+	records := 0
+	write := func(op Op) error {
+		line, err := json.Marshal(journalRecord{Ops: []Op{op}})
+		if err != nil {
+			return err
+		}
+		line = append(line, '\n')
+		size += int64(len(line))
+		records++
+		_, err = writer.Write(line)
+		return err
+	}
+
+	for _, name := range s.bucketNames() {
+		idx := s.buckets[name]
+		md := idx.md
+		if err = write(Op{Kind: opPutBucket, Bucket: name, BucketMD: &md}); err != nil {
+			break
+		}
+		for _, key := range idx.keys {
+			versions, ok := idx.versions[key]
+			if !ok {
+				o := idx.objects[key]
+				if err = write(Op{Kind: opPutObject, Bucket: name, Key: key, Object: &o}); err != nil {
+					break
+				}
+				continue
+			}
+			// oldest first, each replayed version lands in front
+			for i := len(versions) - 1; i >= 0 && err == nil; i-- {
+				v := versions[i]
+				err = write(Op{Kind: opPutVersion, Bucket: name, Key: key, Object: &v})
+			}
+			if err != nil {
+				break
+			}
+		}
+		for id, u := range idx.uploads {
+			if err != nil {
+				break
+			}
+			err = write(Op{Kind: opPutUpload, Bucket: name, UploadID: id, Upload: &u})
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = writer.Flush()
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+
+	if err = os.Rename(tmpPath, s.path); err != nil {
+		tmp.Close()
+		os.Remove(tmpPath)
+		return err
+	}
+	syncDir(filepath.Dir(s.path))
+	s.file.Close()
+	s.file = tmp
+	s.size = size
+	s.records = records
+	return nil
+}
+
+func (s *journalStore) bucketNames() []string {
+	names := make([]string, 0, len(s.buckets))
+	for name := range s.buckets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (s *journalStore) Bucket(name string) (Bucket, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[name]
+	if !ok {
+		return Bucket{}, false
+	}
+	return idx.md, true
+}
+
+func (s *journalStore) Buckets() []Bucket {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	buckets := make([]Bucket, 0, len(s.buckets))
+	for _, name := range s.bucketNames() {
+		buckets = append(buckets, s.buckets[name].md)
+	}
+	return buckets
+}
+
+func (s *journalStore) Object(bucket, key string) (ObjectMD, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return ObjectMD{}, false
+	}
+	o, ok := idx.objects[key]
+	return o, ok
+}
+
+func (s *journalStore) Objects(bucket string) []ObjectMD {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	objects := make([]ObjectMD, 0, len(idx.objects))
+	for _, key := range idx.keys {
+		if o, ok := idx.objects[key]; ok {
+			objects = append(objects, o)
+		}
+	}
+	return objects
+}
+
+// WalkObjects calls fn for the objects of bucket in key order, starting at
+// the first key not less than from, until fn returns false.
+func (s *journalStore) WalkObjects(bucket, from string, fn func(o ObjectMD) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return
+	}
+	for i := sort.SearchStrings(idx.keys, from); i < len(idx.keys); i++ {
+		o, ok := idx.objects[idx.keys[i]]
+		if !ok {
+			continue
+		}
+		if !fn(o) {
+			return
+		}
+	}
+}
+
+// Versions returns every version of key, newest first, delete markers
+// included.
+func (s *journalStore) Versions(bucket, key string) []ObjectMD {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	return idx.history(key)
+}
+
+// WalkVersions calls fn with the history of every key of bucket in key
+// order, starting at the first key not less than from, until fn returns
+// false.
+func (s *journalStore) WalkVersions(bucket, from string, fn func(key string, versions []ObjectMD) bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return
+	}
+	for i := sort.SearchStrings(idx.keys, from); i < len(idx.keys); i++ {
+		if !fn(idx.keys[i], idx.history(idx.keys[i])) {
+			return
+		}
+	}
+}
+
+func (s *journalStore) ObjectCount(bucket string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if idx, ok := s.buckets[bucket]; ok {
+		return len(idx.objects)
+	}
+	return 0
+}
+
+// KeyCount counts the keys of bucket that hold any version, delete markers
+// included.
+func (s *journalStore) KeyCount(bucket string) int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if idx, ok := s.buckets[bucket]; ok {
+		return len(idx.keys)
+	}
+	return 0
+}
+
+func (s *journalStore) Stats() StoreStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	stats := StoreStats{Buckets: len(s.buckets)}
+	for _, idx := range s.buckets {
+		stats.Objects += len(idx.objects)
+		for _, key := range idx.keys {
+			for _, v := range idx.history(key) {
+				if v.DeleteMarker {
+					continue
+				}
+				size, _ := strconv.ParseInt(v.Size, 10, 64)
+				stats.Versions++
+				stats.Bytes += size
+			}
+		}
+	}
+	return stats
+}
+
+func (s *journalStore) Upload(bucket, uploadID string) (Upload, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return Upload{}, false
+	}
+	u, ok := idx.uploads[uploadID]
+	return u, ok
+}
+
+// Uploads returns the multipart uploads in progress, ordered by key and
+// then by upload ID.
+func (s *journalStore) Uploads(bucket string) []Upload {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	idx, ok := s.buckets[bucket]
+	if !ok {
+		return nil
+	}
+	uploads := make([]Upload, 0, len(idx.uploads))
+	for _, u := range idx.uploads {
+		uploads = append(uploads, u)
+	}
+	sort.Slice(uploads, func(i, j int) bool {
+		if uploads[i].ObjectKey != uploads[j].ObjectKey {
+			return uploads[i].ObjectKey < uploads[j].ObjectKey
+		}
+		return uploads[i].UploadID < uploads[j].UploadID
+	})
+	return uploads
+}
+
+func (s *journalStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Sync()
+	if closeErr := s.file.Close(); err == nil {
+		err = closeErr
+	}
+	s.file = nil
+	return err
+}
+
+func syncDir(path string) error {
+	dir, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer dir.Close()
+	return dir.Sync()
+}
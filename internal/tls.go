@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// TLSCertificates serves the server certificate, and the CAs client
+// certificates are checked against when mutual TLS is on, from files that
+// can be reloaded while the server runs.
+type TLSCertificates struct {
+	certFile     string
+	keyFile      string
+	clientCAFile string
+
+	mu     sync.RWMutex
+	config *tls.Config
+	stamp  string // sizes and modification times of the files loaded
+}
+
+// LoadTLSCertificates reads the certificate, its key and, when clientCAFile
+// is set, the PEM bundle of CAs that must have signed client certificates.
+func LoadTLSCertificates(certFile, keyFile, clientCAFile string) (*TLSCertificates, error) {
+	t := &TLSCertificates{certFile: certFile, keyFile: keyFile, clientCAFile: clientCAFile}
+	if err := t.Reload(); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Config returns the TLS configuration for http.Server. Every handshake
+// picks up the files loaded last.
+func (t *TLSCertificates) Config() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			t.mu.RLock()
+			defer t.mu.RUnlock()
+			return t.config, nil
+		},
+	}
+}
+
+// Reload reads the files again. On failure the certificates in use are kept.
+func (t *TLSCertificates) Reload() error {
+	stamp := t.fileStamp()
+	cert, err := tls.LoadX509KeyPair(t.certFile, t.keyFile)
+	if err != nil {
+		return fmt.Errorf("Error: loading the TLS certificate: %w", err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2", "http/1.1"},
+		Certificates: []tls.Certificate{cert},
+	}
+	if t.clientCAFile != "" {
+		pem, err := os.ReadFile(t.clientCAFile)
+		if err != nil {
+			return fmt.Errorf("Error: loading the client CAs: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return errors.New("Error: " + t.clientCAFile + " holds no PEM certificates.")
+		}
+		config.ClientCAs = pool
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	t.mu.Lock()
+	t.config = config
+	t.stamp = stamp
+	t.mu.Unlock()
+	return nil
+}
+
+// Watch reloads the files whenever one of them changes, checking every
+// interval until ctx is done.
+func (t *TLSCertificates) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		t.mu.RLock()
+		changed := t.fileStamp() != t.stamp
+		t.mu.RUnlock()
+		if !changed {
+			continue
+		}
+		if err := t.Reload(); err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("TLS certificates reloaded")
+	}
+}
+
+// fileStamp sums up the files so a change to any of them shows.
+func (t *TLSCertificates) fileStamp() string {
+	var stamp string
+	for _, name := range []string{t.certFile, t.keyFile, t.clientCAFile} {
+		if name == "" {
+			continue
+		}
+		if info, err := os.Stat(name); err == nil {
+			stamp += fmt.Sprintf("%d@%d;", info.Size(), info.ModTime().UnixNano())
+		}
+	}
+	return stamp
+}
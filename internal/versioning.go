@@ -0,0 +1,403 @@
+package internal
+
+import (
+	"encoding/xml"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// versionsDirName holds the data of versioned objects inside a bucket, one
+// directory per key.
+const versionsDirName = ".versions"
+
+const (
+	versioningEnabled   = "Enabled"
+	versioningSuspended = "Suspended"
+	nullVersionID       = "null"
+)
+
+var (
+	errNoSuchKey     = errors.New("object key does not exist.")
+	errNoSuchVersion = errors.New("The specified version does not exist.")
+	errDeleteMarker  = errors.New("The specified method is not allowed against a delete marker.")
+)
+
+// versionPath is where one version of a key is kept.
+func (s *Server) versionPath(bucketName, objectKey, versionID string) string {
+	return filepath.Join(s.directory, bucketName, versionsDirName, keyFileName(objectKey), versionID)
+}
+
+// objectPath is where the data of o lives: objects written without
+// versioning sit in the bucket directory, versions under versionsDirName.
+// Every write gets a file of its own, named after o.DataName, so the data of
+// the version it replaces stays in place until the new one is committed.
+func (s *Server) objectPath(bucketName string, o ObjectMD) string {
+	path := s.versionPath(bucketName, o.ObjectKey, o.VersionID)
+	if o.VersionID == "" {
+		path = filepath.Join(s.directory, bucketName, keyFileName(o.ObjectKey))
+	}
+	return withDataName(path, o.DataName)
+}
+
+// prepareObjectPath picks a new data file for o and returns its path,
+// creating its version directory when needed.
+func (s *Server) prepareObjectPath(bucketName string, o *ObjectMD) (string, error) {
+	dataName, err := newUploadID()
+	if err != nil {
+		return "", err
+	}
+	o.DataName = dataName
+	path := s.objectPath(bucketName, *o)
+	if o.VersionID != "" {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return "", err
+		}
+	}
+	return path, nil
+}
+
+// removeObjectData deletes the data file of a version that is gone from the
+// store, along with its version directory once that is empty.
+func (s *Server) removeObjectData(bucketName string, o ObjectMD) error {
+	if o.DeleteMarker {
+		return nil
+	}
+	path := s.objectPath(bucketName, o)
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if o.VersionID != "" {
+		os.Remove(filepath.Dir(path))
+	}
+	return nil
+}
+
+// displayVersionID is the version ID clients see, objects written before
+// versioning was enabled are the "null" version.
+func displayVersionID(versionID string) string {
+	if versionID == "" {
+		return nullVersionID
+	}
+	return versionID
+}
+
+func sameVersion(a, b string) bool {
+	return displayVersionID(a) == displayVersionID(b)
+}
+
+// nextVersionID is the version ID a new object or delete marker gets in
+// bucket, empty while versioning has never been enabled.
+func nextVersionID(bucket Bucket) (string, error) {
+	switch bucket.Versioning {
+	case versioningEnabled:
+		return newUploadID()
+	case versioningSuspended:
+		return nullVersionID, nil
+	}
+	return "", nil
+}
+
+// addObjectVersion adds o to tx as the new current version of its key and
+// returns the version it replaces for good, if any: the object written
+// before it without versioning or, with versioning suspended, the null
+// version.
+func (s *Server) addObjectVersion(tx *Tx, bucketName string, o ObjectMD) (ObjectMD, bool) {
+	if o.VersionID == "" {
+		tx.PutObject(bucketName, o)
+		return s.store.Object(bucketName, o.ObjectKey)
+	}
+	tx.PutVersion(bucketName, o)
+	if o.VersionID != nullVersionID {
+		return ObjectMD{}, false
+	}
+	for _, v := range s.store.Versions(bucketName, o.ObjectKey) {
+		if sameVersion(v.VersionID, nullVersionID) {
+			return v, true
+		}
+	}
+	return ObjectMD{}, false
+}
+
+// addDeleteMarker adds a delete marker for objectKey to tx and returns the
+// null version it replaces, if any.
+func (s *Server) addDeleteMarker(tx *Tx, bucketName, objectKey, versionID, now string) (ObjectMD, bool) {
+	return s.addObjectVersion(tx, bucketName, ObjectMD{
+		ObjectKey:    objectKey,
+		LastModified: now,
+		VersionID:    versionID,
+		DeleteMarker: true,
+	})
+}
+
+// findObject returns the version of an object a GET or HEAD asks for with
+// ?versionId=, or the current one. When a delete marker hides the object the
+// marker is described in the response headers.
+func (s *Server) findObject(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) (ObjectMD, error) {
+	return s.findObjectVersion(w.Header(), bucketName, objectKey, r.URL.Query().Get("versionId"))
+}
+
+// findObjectVersion looks up one version of an object, the current one when
+// versionID is empty. A delete marker in the way is described in header.
+func (s *Server) findObjectVersion(header http.Header, bucketName, objectKey, versionID string) (ObjectMD, error) {
+	if versionID == "" {
+		if o, ok := s.store.Object(bucketName, objectKey); ok {
+			return o, nil
+		}
+		if versions := s.store.Versions(bucketName, objectKey); len(versions) > 0 && versions[0].DeleteMarker {
+			header.Set("x-amz-delete-marker", "true")
+			header.Set("x-amz-version-id", versions[0].VersionID)
+		}
+		return ObjectMD{}, errNoSuchKey
+	}
+
+	for _, v := range s.store.Versions(bucketName, objectKey) {
+		if !sameVersion(v.VersionID, versionID) {
+			continue
+		}
+		if v.DeleteMarker {
+			header.Set("x-amz-delete-marker", "true")
+			header.Set("x-amz-version-id", v.VersionID)
+			return ObjectMD{}, errDeleteMarker
+		}
+		return v, nil
+	}
+	return ObjectMD{}, errNoSuchVersion
+}
+
+// writeVersionError reports a version findObject could not serve.
+func writeVersionError(w http.ResponseWriter, err error) {
+	switch err {
+	case errDeleteMarker:
+		w.Header().Set("Allow", "DELETE")
+		writeXMLError(w, "MethodNotAllowed", err.Error())
+	case errNoSuchVersion:
+		writeXMLError(w, "NoSuchVersion", err.Error())
+	default:
+		writeXMLError(w, "NoSuchKey", err.Error())
+	}
+}
+
+// deleteVersioned answers DELETE on an object in a bucket that has had
+// versioning enabled: without ?versionId= a delete marker becomes the
+// current version, with it that one version is removed for good.
+func (s *Server) deleteVersioned(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) {
+	query := r.URL.Query()
+	now := time.Now().Format(timeFormat)
+	tx := &Tx{}
+	var removed ObjectMD
+	var hasRemoved bool
+
+	unlockMeta := s.locks.lock(bucketMetaLockKey(bucketName))
+	bucket, _ := s.store.Bucket(bucketName)
+	if query.Has("versionId") {
+		versionID := query.Get("versionId")
+		for _, v := range s.store.Versions(bucketName, objectKey) {
+			if sameVersion(v.VersionID, versionID) {
+				removed, hasRemoved = v, true
+				break
+			}
+		}
+		if !hasRemoved {
+			unlockMeta()
+			// deleting a version that is already gone succeeds
+			w.Header().Set("x-amz-version-id", versionID)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		tx.DeleteVersion(bucketName, objectKey, removed.VersionID)
+		w.Header().Set("x-amz-version-id", displayVersionID(removed.VersionID))
+		if removed.DeleteMarker {
+			w.Header().Set("x-amz-delete-marker", "true")
+		}
+	} else {
+		versionID, err := nextVersionID(bucket)
+		if err != nil {
+			unlockMeta()
+			writeXMLError(w, "InternalError", err.Error())
+			return
+		}
+		removed, hasRemoved = s.addDeleteMarker(tx, bucketName, objectKey, versionID, now)
+		w.Header().Set("x-amz-version-id", versionID)
+		w.Header().Set("x-amz-delete-marker", "true")
+	}
+	err := s.store.Commit(tx)
+	unlockMeta()
+	if err != nil {
+		w.Header().Del("x-amz-version-id")
+		w.Header().Del("x-amz-delete-marker")
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	if hasRemoved {
+		if err = s.removeObjectData(bucketName, removed); err != nil {
+			writeXMLError(w, "InternalError", err.Error())
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// putBucketVersioning answers PUT /{bucket}?versioning. Versioning can be
+// suspended but never turned off again.
+func (s *Server) putBucketVersioning(w http.ResponseWriter, r *http.Request, bucketName string) {
+	var configuration VersioningConfiguration
+	if err := xml.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&configuration); err != nil {
+		writeXMLError(w, "MalformedXML", "")
+		return
+	}
+	if configuration.Status != versioningEnabled && configuration.Status != versioningSuspended {
+		writeXMLError(w, "IllegalVersioningConfigurationException", "Versioning status must be Enabled or Suspended.")
+		return
+	}
+
+	// wait for object writes in flight, they picked their version IDs already
+	defer s.locks.lock(bucketLockKey(bucketName))()
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	if !checkBucketWritable(w, bucket) {
+		return
+	}
+	bucket.Versioning = configuration.Status
+	tx := &Tx{}
+	tx.PutBucket(bucket)
+	if err := s.store.Commit(tx); err != nil {
+		writeXMLError(w, "InternalError", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// getBucketVersioning answers GET /{bucket}?versioning.
+func (s *Server) getBucketVersioning(w http.ResponseWriter, r *http.Request, bucketName string) {
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	bucket, ok := s.store.Bucket(bucketName)
+	if !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+	writeXML(w, VersioningConfiguration{
+		Xmlns:  "http://s3.amazonaws.com/doc/2006-03-01/",
+		Status: bucket.Versioning,
+	}, http.StatusOK)
+}
+
+// listObjectVersions answers GET /{bucket}?versions with every version and
+// delete marker, newest first within a key.
+func (s *Server) listObjectVersions(w http.ResponseWriter, r *http.Request, bucketName string) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+	delimiter := query.Get("delimiter")
+	keyMarker := query.Get("key-marker")
+	versionIDMarker := query.Get("version-id-marker")
+	maxKeys, err := queryInt(query.Get("max-keys"), maxListKeys)
+	if err != nil {
+		writeXMLError(w, "InvalidArgument", "max-keys must be a non-negative integer.")
+		return
+	}
+	maxKeys = min(maxKeys, maxListKeys)
+	if versionIDMarker != "" && keyMarker == "" {
+		writeXMLError(w, "InvalidArgument", "A version-id-marker cannot be specified without a key-marker.")
+		return
+	}
+
+	defer s.locks.rlock(bucketLockKey(bucketName))()
+	if _, ok := s.store.Bucket(bucketName); !ok {
+		writeXMLError(w, "NoSuchBucket", "")
+		return
+	}
+
+	result := ListVersionsResult{
+		Name:            bucketName,
+		Prefix:          prefix,
+		Delimiter:       delimiter,
+		KeyMarker:       keyMarker,
+		VersionIdMarker: versionIDMarker,
+		MaxKeys:         maxKeys,
+	}
+	count := 0
+	skipPrefix := keyMarker
+	s.store.WalkVersions(bucketName, max(keyMarker, prefix), func(key string, versions []ObjectMD) bool {
+		if !strings.HasPrefix(key, prefix) {
+			// keys are sorted, nothing after this one can match
+			return false
+		}
+		if key == keyMarker {
+			if versionIDMarker == "" {
+				return true
+			}
+			// resume after the last version of the previous page
+			for i, v := range versions {
+				if sameVersion(v.VersionID, versionIDMarker) {
+					versions = versions[i+1:]
+					break
+				}
+			}
+		}
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if common == skipPrefix {
+					return true
+				}
+				if count == maxKeys {
+					result.IsTruncated = true
+					return false
+				}
+				result.CommonPrefixes = append(result.CommonPrefixes, CommonPrefix{Prefix: common})
+				count++
+				skipPrefix = common
+				result.NextKeyMarker = common
+				result.NextVersionIdMarker = ""
+				return true
+			}
+		}
+		latest := key != keyMarker || versionIDMarker == ""
+		for _, v := range versions {
+			if count == maxKeys {
+				result.IsTruncated = true
+				return false
+			}
+			lastModified := parseTime(v.LastModified).UTC().Format(isoTimeFormat)
+			if v.DeleteMarker {
+				result.DeleteMarkers = append(result.DeleteMarkers, DeleteMarkerInfo{
+					Key:          key,
+					VersionId:    displayVersionID(v.VersionID),
+					IsLatest:     latest,
+					LastModified: lastModified,
+				})
+			} else {
+				result.Versions = append(result.Versions, VersionInfo{
+					Key:          key,
+					VersionId:    displayVersionID(v.VersionID),
+					IsLatest:     latest,
+					LastModified: lastModified,
+					ETag:         quoteETag(v.ETag),
+					Size:         v.Size,
+					StorageClass: "STANDARD",
+				})
+			}
+			latest = false
+			count++
+			result.NextKeyMarker = key
+			result.NextVersionIdMarker = displayVersionID(v.VersionID)
+		}
+		return true
+	})
+	// an empty page has no marker to carry on from, see listObjects
+	if count == 0 {
+		result.IsTruncated = false
+	}
+	if !result.IsTruncated {
+		result.NextKeyMarker = ""
+		result.NextVersionIdMarker = ""
+	}
+	writeXML(w, result, http.StatusOK)
+}
@@ -0,0 +1,54 @@
+package internal
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// FuzzObjectPath checks that no accepted key puts an object outside its
+// bucket directory or onto one of the server's own names.
+func FuzzObjectPath(f *testing.F) {
+	for _, seed := range []string{"", "key", "dir/key", ".", "..", "../key", "../../_meta", "_meta", ".multipart", ".versions", "/", "a/../../b"} {
+		f.Add(seed)
+	}
+	dir := f.TempDir()
+	s := &Server{directory: dir}
+	bucketDir := filepath.Join(dir, "bucket")
+	reserved := map[string]bool{metaDirName: true, multipartDirName: true, versionsDirName: true, ".": true, "..": true}
+
+	f.Fuzz(func(t *testing.T, key string) {
+		if validateObjectKey(key) != nil {
+			return
+		}
+		for _, versionID := range []string{"", nullVersionID, "0123456789abcdef0123456789abcdef"} {
+			for _, dataName := range []string{"", "fedcba9876543210fedcba9876543210"} {
+				o := ObjectMD{ObjectKey: key, VersionID: versionID, DataName: dataName}
+				paths := []string{s.objectPath("bucket", o)}
+				if versionID != "" {
+					paths = append(paths, s.versionPath("bucket", key, versionID))
+				}
+				for _, path := range paths {
+					rel, err := filepath.Rel(bucketDir, path)
+					if err != nil || filepath.Join(bucketDir, rel) != path {
+						t.Fatalf("key %q: %s is not a clean path under %s", key, path, bucketDir)
+					}
+					elements := strings.Split(rel, string(filepath.Separator))
+					if versionID != "" {
+						if len(elements) != 3 || elements[0] != versionsDirName {
+							t.Fatalf("key %q: version path %s is not under %s", key, path, versionsDirName)
+						}
+						elements = elements[1:]
+					} else if len(elements) != 1 {
+						t.Fatalf("key %q: %s is not directly in %s", key, path, bucketDir)
+					}
+					for _, element := range elements {
+						if reserved[element] {
+							t.Fatalf("key %q: %s uses the reserved name %q", key, path, element)
+						}
+					}
+				}
+			}
+		}
+	})
+}
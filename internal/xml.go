@@ -2,12 +2,7 @@ package internal
 
 import (
 	"encoding/xml"
-	"errors"
-	"mime"
 	"net/http"
-	"os"
-	"path/filepath"
-	"triple-s/config"
 )
 
 type Bucket struct {
@@ -15,21 +10,226 @@ type Bucket struct {
 	DateOfCreation   string `xml:"DateOfCreation"`
 	LastModifiedTime string `xml:"LastModifiedTime"`
 	Status           string `xml:"Status"`
+	Versioning       string `xml:"-"` // "", Enabled or Suspended
 }
 
 type Buckets struct {
 	SliceBucket []Bucket `xml:"Bucket"`
 }
 
+// ListAllMyBucketsResult is the bucket list of the legacy /get/ route.
 type ListAllMyBucketsResult struct {
 	XMLName xml.Name `xml:"ListAllMyBucketsResult"`
 	Buckets Buckets  `xml:"Buckets"`
 }
 
+// ListBucketsResult is the bucket list as S3 sends it from GET /.
+type ListBucketsResult struct {
+	XMLName xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListAllMyBucketsResult"`
+	Owner   Owner        `xml:"Owner"`
+	Buckets []BucketInfo `xml:"Buckets>Bucket"`
+}
+
+type Owner struct {
+	ID          string `xml:"ID"`
+	DisplayName string `xml:"DisplayName"`
+}
+
+type BucketInfo struct {
+	Name         string `xml:"Name"`
+	CreationDate string `xml:"CreationDate"`
+}
+
+type ListBucketResult struct {
+	XMLName               xml.Name       `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string         `xml:"Name"`
+	Prefix                string         `xml:"Prefix"`
+	Delimiter             string         `xml:"Delimiter,omitempty"`
+	MaxKeys               int            `xml:"MaxKeys"`
+	KeyCount              int            `xml:"KeyCount"`
+	IsTruncated           bool           `xml:"IsTruncated"`
+	ContinuationToken     string         `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string         `xml:"NextContinuationToken,omitempty"`
+	StartAfter            string         `xml:"StartAfter,omitempty"`
+	EncodingType          string         `xml:"EncodingType,omitempty"`
+	Contents              []Contents     `xml:"Contents"`
+	CommonPrefixes        []CommonPrefix `xml:"CommonPrefixes"`
+}
+
+type Contents struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         string `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type CommonPrefix struct {
+	Prefix string `xml:"Prefix"`
+}
+
+type InitiateMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ InitiateMultipartUploadResult"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	UploadId string   `xml:"UploadId"`
+}
+
+type CompleteMultipartUpload struct {
+	XMLName xml.Name       `xml:"CompleteMultipartUpload"`
+	Parts   []CompletePart `xml:"Part"`
+}
+
+type CompletePart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+type CompleteMultipartUploadResult struct {
+	XMLName  xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CompleteMultipartUploadResult"`
+	Location string   `xml:"Location"`
+	Bucket   string   `xml:"Bucket"`
+	Key      string   `xml:"Key"`
+	ETag     string   `xml:"ETag"`
+}
+
+type CopyObjectResult struct {
+	XMLName      xml.Name `xml:"http://s3.amazonaws.com/doc/2006-03-01/ CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+type ListPartsResult struct {
+	XMLName              xml.Name   `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListPartsResult"`
+	Bucket               string     `xml:"Bucket"`
+	Key                  string     `xml:"Key"`
+	UploadId             string     `xml:"UploadId"`
+	PartNumberMarker     int        `xml:"PartNumberMarker"`
+	NextPartNumberMarker int        `xml:"NextPartNumberMarker"`
+	MaxParts             int        `xml:"MaxParts"`
+	IsTruncated          bool       `xml:"IsTruncated"`
+	StorageClass         string     `xml:"StorageClass"`
+	Parts                []PartInfo `xml:"Part"`
+}
+
+type PartInfo struct {
+	PartNumber   int    `xml:"PartNumber"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int64  `xml:"Size"`
+}
+
+type ListMultipartUploadsResult struct {
+	XMLName            xml.Name     `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListMultipartUploadsResult"`
+	Bucket             string       `xml:"Bucket"`
+	KeyMarker          string       `xml:"KeyMarker"`
+	UploadIdMarker     string       `xml:"UploadIdMarker"`
+	NextKeyMarker      string       `xml:"NextKeyMarker"`
+	NextUploadIdMarker string       `xml:"NextUploadIdMarker"`
+	Prefix             string       `xml:"Prefix"`
+	MaxUploads         int          `xml:"MaxUploads"`
+	IsTruncated        bool         `xml:"IsTruncated"`
+	Uploads            []UploadInfo `xml:"Upload"`
+}
+
+type UploadInfo struct {
+	Key          string `xml:"Key"`
+	UploadId     string `xml:"UploadId"`
+	Initiated    string `xml:"Initiated"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type VersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Xmlns   string   `xml:"xmlns,attr,omitempty"`
+	Status  string   `xml:"Status,omitempty"`
+}
+
+type ListVersionsResult struct {
+	XMLName             xml.Name           `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListVersionsResult"`
+	Name                string             `xml:"Name"`
+	Prefix              string             `xml:"Prefix"`
+	Delimiter           string             `xml:"Delimiter,omitempty"`
+	KeyMarker           string             `xml:"KeyMarker"`
+	VersionIdMarker     string             `xml:"VersionIdMarker"`
+	NextKeyMarker       string             `xml:"NextKeyMarker,omitempty"`
+	NextVersionIdMarker string             `xml:"NextVersionIdMarker,omitempty"`
+	MaxKeys             int                `xml:"MaxKeys"`
+	IsTruncated         bool               `xml:"IsTruncated"`
+	Versions            []VersionInfo      `xml:"Version"`
+	DeleteMarkers       []DeleteMarkerInfo `xml:"DeleteMarker"`
+	CommonPrefixes      []CommonPrefix     `xml:"CommonPrefixes"`
+}
+
+type VersionInfo struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         string `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type DeleteMarkerInfo struct {
+	Key          string `xml:"Key"`
+	VersionId    string `xml:"VersionId"`
+	IsLatest     bool   `xml:"IsLatest"`
+	LastModified string `xml:"LastModified"`
+}
+
+type Delete struct {
+	XMLName xml.Name           `xml:"Delete"`
+	Quiet   bool               `xml:"Quiet"`
+	Objects []ObjectIdentifier `xml:"Object"`
+}
+
+type ObjectIdentifier struct {
+	Key       string `xml:"Key"`
+	VersionId string `xml:"VersionId"`
+}
+
+type DeleteResult struct {
+	XMLName xml.Name        `xml:"http://s3.amazonaws.com/doc/2006-03-01/ DeleteResult"`
+	Deleted []DeletedObject `xml:"Deleted"`
+	Errors  []DeleteError   `xml:"Error"`
+}
+
+type DeletedObject struct {
+	Key                   string `xml:"Key"`
+	VersionId             string `xml:"VersionId,omitempty"`
+	DeleteMarker          bool   `xml:"DeleteMarker,omitempty"`
+	DeleteMarkerVersionId string `xml:"DeleteMarkerVersionId,omitempty"`
+}
+
+type DeleteError struct {
+	Key       string `xml:"Key"`
+	VersionId string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
+// BucketDeletion reports the progress of a force delete.
+type BucketDeletion struct {
+	XMLName       xml.Name `xml:"BucketDeletion"`
+	Bucket        string   `xml:"Bucket"`
+	Status        string   `xml:"Status"`
+	Started       string   `xml:"Started"`
+	Finished      string   `xml:"Finished,omitempty"`
+	KeysDeleted   int      `xml:"KeysDeleted"`
+	KeysRemaining int      `xml:"KeysRemaining"`
+	Error         string   `xml:"Error,omitempty"`
+}
+
+// ErrorResponse is the S3 error body. Resource and RequestId name the
+// request that failed, HostId the server that answered it.
 type ErrorResponse struct {
-	XMLName    xml.Name `xml:"Error"`
-	StatusCode string   `xml:"StatusCode"`
-	Message    string   `xml:"Message"`
+	XMLName   xml.Name `xml:"Error"`
+	Code      string   `xml:"Code"`
+	Message   string   `xml:"Message"`
+	Resource  string   `xml:"Resource,omitempty"`
+	RequestId string   `xml:"RequestId,omitempty"`
+	HostId    string   `xml:"HostId"`
 }
 
 type Response struct {
@@ -37,27 +237,8 @@ type Response struct {
 	Message    string `xml:"Message"`
 }
 
-func listAllMyBucketsResult() ([]byte, error) {
-	records, err := readCSV("/buckets.csv")
-	if err != nil {
-		return nil, err
-	}
-	buckets := Buckets{}
-	for i, record := range records {
-		if i == 0 {
-			continue
-		}
-		if len(record) < 4 {
-			return nil, errors.New("csv record is not correct")
-		}
-		soloBucket := Bucket{
-			Name:             record[0],
-			DateOfCreation:   record[1],
-			LastModifiedTime: record[2],
-			Status:           record[3],
-		}
-		buckets.SliceBucket = append(buckets.SliceBucket, soloBucket)
-	}
+func (s *Server) listAllMyBucketsResult() ([]byte, error) {
+	buckets := Buckets{SliceBucket: s.store.Buckets()}
 
 	result := ListAllMyBucketsResult{
 		Buckets: buckets,
@@ -71,51 +252,44 @@ func listAllMyBucketsResult() ([]byte, error) {
 	return xmlData, nil
 }
 
-func listObjectData(w http.ResponseWriter, r *http.Request, bucketName, objectKey string) error {
-	// Construct the file path
-	filePath := filepath.Join(config.Directory, bucketName, objectKey)
-
-	// Open the file
-	file, err := os.Open(filePath)
+func writeXML(w http.ResponseWriter, v any, code int) {
+	xmlData, err := xml.MarshalIndent(v, "", "   ")
 	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Get file info for modification time
-	fileInfo, err := file.Stat()
-	if err != nil {
-		return err
-	}
-
-	// Detect the content type based on the file extension
-	ext := filepath.Ext(filePath)
-	contentType := mime.TypeByExtension(ext)
-	if contentType == "" {
-		// Fallback content type if not determined by extension
-		contentType = "application/octet-stream"
+		writeXMLError(w, "InternalError", err.Error())
+		return
 	}
-	w.Header().Set("Content-Type", contentType)
-
-	// Serve the content using http.ServeContent
-	http.ServeContent(w, r, objectKey, fileInfo.ModTime(), file)
-	return nil
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(code)
+	w.Write([]byte(xml.Header))
+	w.Write(append(xmlData, '\n'))
 }
 
-func writeXMLError(w http.ResponseWriter, statusCode, message string, code int) {
+// writeXMLError answers with an S3 error code from s3Errors, which decides
+// the HTTP status. An empty message uses the default one of the code.
+func writeXMLError(w http.ResponseWriter, code, message string) {
+	code, e := lookupS3Error(code)
+	if message == "" {
+		message = e.message
+	}
 	errorResponse := ErrorResponse{
-		StatusCode: statusCode,
-		Message:    message,
+		Code:    code,
+		Message: message,
+		HostId:  hostID,
+	}
+	if recorder, ok := w.(*responseRecorder); ok {
+		errorResponse.Resource = recorder.resource
+		errorResponse.RequestId = recorder.requestID
 	}
 	xmlData, err := xml.MarshalIndent(errorResponse, "", "   ")
 	if err != nil {
-		errorResponse.StatusCode = "Internal Server Error"
-		errorResponse.Message = "Error: error in xml.MarshalIndent"
+		code, e = lookupS3Error("InternalError")
+		xmlData, _ = xml.Marshal(ErrorResponse{Code: code, Message: e.message, HostId: hostID})
 	}
-	xmlData = append(xmlData, '\n')
+	recordErrorCode(w, code)
 	w.Header().Set("Content-Type", "application/xml")
-	w.WriteHeader(code)
-	w.Write(xmlData)
+	w.WriteHeader(e.status)
+	w.Write([]byte(xml.Header))
+	w.Write(append(xmlData, '\n'))
 }
 
 func writeXMLResponse(w http.ResponseWriter, statusCode, message string, code int) {
@@ -126,7 +300,7 @@ func writeXMLResponse(w http.ResponseWriter, statusCode, message string, code in
 
 	xmlData, err := xml.MarshalIndent(response, "", "   ")
 	if err != nil {
-		writeXMLError(w, "Internal Server Error", "Error: error in xml.MarshalIndent", code)
+		writeXMLError(w, "InternalError", err.Error())
 		return
 	}
 	xmlData = append(xmlData, '\n')
@@ -1,43 +1,143 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
 	"log"
 	"net/http"
-	"strings"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 	"triple-s/config"
 	"triple-s/internal"
 )
 
 func main() {
-	if err := config.ValidateDirectory(); err != nil {
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
 		log.Fatal(err)
 	}
 
-	mux := http.NewServeMux()
-	log.Printf("http://localhost:%s/\n", config.PortNumber)
-
-	mux.HandleFunc("/put/", func(w http.ResponseWriter, r *http.Request) {
-		if strings.Count(strings.TrimPrefix(r.URL.Path, "/put/"), "/") == 0 {
-			internal.PutHandler(w, r)
-		} else {
-			internal.UploadNewObject(w, r)
+	if len(args) > 0 && args[0] == "presign" {
+		if err := runPresign(cfg, args[1:]); err != nil {
+			log.Fatal(err)
 		}
+		return
+	}
+
+	if err := cfg.MakeDirectory(); err != nil {
+		log.Fatal(err)
+	}
+	srv, err := internal.NewServer(cfg.Directory)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.ResumeBucketDeletions()
+	err = srv.OpenAccessLog(internal.AccessLogOptions{
+		Path:     cfg.AccessLog,
+		Format:   cfg.AccessLogFormat,
+		MaxBytes: int64(cfg.AccessLogMaxMB) << 20,
+		Backups:  cfg.AccessLogBackups,
+		Bucket:   cfg.AccessLogBucket,
+		Prefix:   cfg.AccessLogPrefix,
+		Interval: cfg.AccessLogInterval,
 	})
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	mux.HandleFunc("/get/", func(w http.ResponseWriter, r *http.Request) {
-		if strings.Count(strings.TrimPrefix(r.URL.Path, "/get/"), "/") == 0 {
-			internal.GetHandler(w, r)
-		} else {
-			internal.RetrieveObject(w, r)
+	server := &http.Server{
+		Addr:    ":" + cfg.Port,
+		Handler: internal.NewRouter(srv),
+	}
+	var certs *internal.TLSCertificates
+	if cfg.TLSCert != "" {
+		certs, err = internal.LoadTLSCertificates(cfg.TLSCert, cfg.TLSKey, cfg.TLSClientCA)
+		if err != nil {
+			log.Fatal(err)
 		}
-	})
+		server.TLSConfig = certs.Config()
+	}
+	if err := serve(cfg, server, srv, certs); err != nil {
+		log.Fatal(err)
+	}
+}
 
-	mux.HandleFunc("/delete/", func(w http.ResponseWriter, r *http.Request) {
-		if strings.Count(strings.TrimPrefix(r.URL.Path, "/delete/"), "/") == 0 {
-			internal.DeleteHandler(w, r)
-		} else {
-			internal.DeleteAnObject(w, r)
+// serve runs server until SIGINT or SIGTERM, then stops taking connections,
+// waits up to cfg.ShutdownTimeout for requests in flight and closes the
+// metadata store. A second signal during the wait exits at once. With certs
+// the server speaks HTTPS and reloads them on SIGHUP or when the files change.
+func serve(cfg config.Config, server *http.Server, srv *internal.Server, certs *internal.TLSCertificates) error {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	errs := make(chan error, 1)
+	if certs != nil {
+		go certs.Watch(ctx, certWatchInterval)
+		go reloadOnHangup(ctx, certs)
+		go func() {
+			log.Printf("https://localhost:%s/\n", cfg.Port)
+			errs <- server.ListenAndServeTLS("", "")
+		}()
+	} else {
+		go func() {
+			log.Printf("http://localhost:%s/\n", cfg.Port)
+			errs <- server.ListenAndServe()
+		}()
+	}
+	select {
+	case err := <-errs:
+		return err
+	case <-ctx.Done():
+	}
+	stop()
+
+	log.Printf("shutting down, waiting up to %s for requests in flight\n", cfg.ShutdownTimeout)
+	drainCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(drainCtx); err != nil {
+		log.Printf("requests still running after %s, closing their connections\n", cfg.ShutdownTimeout)
+		server.Close()
+	}
+	if err := srv.StopBucketDeletions(drainCtx); err != nil {
+		log.Printf("bucket deletions still running: %v\n", err)
+	}
+	if err := srv.CloseAccessLog(); err != nil {
+		log.Printf("closing the access log: %v\n", err)
+	}
+	if err := srv.Close(); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	log.Println("stopped")
+	return nil
+}
+
+// certWatchInterval is how often the TLS files are checked for changes.
+const certWatchInterval = 10 * time.Second
+
+// reloadOnHangup reloads certs on every SIGHUP until ctx is done.
+func reloadOnHangup(ctx context.Context, certs *internal.TLSCertificates) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
 		}
-	})
-	log.Fatal(http.ListenAndServe(":"+config.PortNumber, mux))
+		if err := certs.Reload(); err != nil {
+			log.Println(err)
+			continue
+		}
+		log.Println("TLS certificates reloaded")
+	}
 }
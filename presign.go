@@ -0,0 +1,42 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+	"triple-s/config"
+	"triple-s/internal"
+)
+
+// runPresign prints a presigned URL:
+//
+//	triple-s [-dir <S>] presign [-method GET|PUT] [-expires 1h] [-access-key <K>] <bucket>/<key>
+func runPresign(cfg config.Config, args []string) error {
+	flags := flag.NewFlagSet("presign", flag.ExitOnError)
+	method := flags.String("method", "GET", "GET for a download link, PUT for an upload link")
+	expires := flags.Duration("expires", time.Hour, "How long the URL stays valid (max 168h)")
+	accessKey := flags.String("access-key", "", "Access key to sign with")
+	scheme := "http"
+	if cfg.TLSCert != "" {
+		scheme = "https"
+	}
+	endpoint := flags.String("endpoint", scheme+"://localhost:"+cfg.Port, "Address clients reach the server at")
+	region := flags.String("region", "us-east-1", "Region written into the signature scope")
+	flags.Parse(args)
+
+	bucketName, objectKey, ok := strings.Cut(flags.Arg(0), "/")
+	if flags.NArg() != 1 || !ok {
+		return fmt.Errorf("Error: usage: triple-s presign [options] <bucket>/<key>")
+	}
+	creds, err := internal.LoadCredentials(cfg.Directory)
+	if err != nil {
+		return err
+	}
+	url, err := creds.PresignURL(strings.ToUpper(*method), *endpoint, bucketName, objectKey, *accessKey, *region, *expires)
+	if err != nil {
+		return err
+	}
+	fmt.Println(url)
+	return nil
+}